@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"text/scanner"
 )
 
 type (
@@ -12,46 +13,58 @@ type (
 		typecheck(mgr *typMgr, env *typEnv) (typ, error)
 		compile(env compEnv, into *[]inst) error
 		resolve(mgr *typMgr) error
+		freeVariables(bound map[string]bool, into map[string]bool)
+		lift(l *lambdaLifter, locals map[string]bool) ast
+		getPosition() scanner.Position
 	}
 
 	pattern interface {
 		fmt.Stringer
 		match(t typ, mgr *typMgr, e *typEnv) error
+		variables() []string
+		getPosition() scanner.Position
 	}
 
 	branch struct {
 		pat     pattern
 		expr    ast
 		nodeTyp typ
+		pos     scanner.Position
 	}
 
 	constructor struct {
 		name    string
-		types   []string
+		types   []parsedType
 		tag     int
 		nodeTyp typ
+		pos     scanner.Position
 	}
 
 	definition interface {
 		typecheckFirst(mgr *typMgr, e *typEnv)
 		typecheckSecond(mgr *typMgr, e *typEnv) error
+		generalize(mgr *typMgr, e *typEnv)
 		resolve(mgr *typMgr) error
 		compile() error
+		getPosition() scanner.Position
 	}
 
 	astInt struct {
 		value   int
 		nodeTyp typ
+		pos     scanner.Position
 	}
 
 	astLID struct {
 		ID      string
 		nodeTyp typ
+		pos     scanner.Position
 	}
 
 	astUID struct {
 		ID      string
 		nodeTyp typ
+		pos     scanner.Position
 	}
 
 	astBinOp struct {
@@ -59,29 +72,63 @@ type (
 		left    ast
 		right   ast
 		nodeTyp typ
+		pos     scanner.Position
 	}
 
 	astApp struct {
 		left    ast
 		right   ast
 		nodeTyp typ
+		pos     scanner.Position
 	}
 
 	astCase struct {
 		of       ast
 		branches []branch
 		nodeTyp  typ
+		pos      scanner.Position
+	}
+
+	letBinding struct {
+		name string
+		expr ast
+		pos  scanner.Position
+	}
+
+	astLet struct {
+		rec      bool
+		bindings []letBinding
+		body     ast
+		nodeTyp  typ
+		pos      scanner.Position
+	}
+
+	astLambda struct {
+		params  []string
+		body    ast
+		nodeTyp typ
+		pos     scanner.Position
+	}
+
+	astIf struct {
+		cond     ast
+		thenExpr ast
+		elseExpr ast
+		nodeTyp  typ
+		pos      scanner.Position
 	}
 
 	patternVar struct {
 		variable string
 		nodeTyp  typ
+		pos      scanner.Position
 	}
 
 	patternConstr struct {
 		constr  string
 		params  []string
 		nodeTyp typ
+		pos     scanner.Position
 	}
 
 	definitionDefn struct {
@@ -92,16 +139,19 @@ type (
 		returnType   typ
 		nodeTyp      typ
 		instructions []inst
+		pos          scanner.Position
 	}
 
 	definitionData struct {
 		name         string
+		params       []string
 		constructors []constructor
 		nodeTyp      typ
+		pos          scanner.Position
 	}
 )
 
-func newDefinitionDefn(name string, params []string, body ast) *definitionDefn {
+func newDefinitionDefn(name string, params []string, body ast, pos scanner.Position) *definitionDefn {
 	return &definitionDefn{
 		name,
 		params,
@@ -110,5 +160,6 @@ func newDefinitionDefn(name string, params []string, body ast) *definitionDefn {
 		nil,
 		nil,
 		make([]inst, 0),
+		pos,
 	}
 }
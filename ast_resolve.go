@@ -1,17 +1,14 @@
 package main
 
 import (
-	"fmt"
-
 	"github.com/pkg/errors"
 )
 
+// Type variables may remain after resolving, as generalized
+// definitions are polymorphic in them.
 func resolveCommon(a ast, mgr *typMgr) error {
 	var v *typVar
 	resolvedType := mgr.resolve(a.getNodeType(), &v)
-	if v != nil {
-		return fmt.Errorf("Ambiguously typed program in resolveCommon: %v", a)
-	}
 
 	err := a.resolve(mgr)
 	if err != nil {
@@ -78,6 +75,50 @@ func (a *astCase) resolve(mgr *typMgr) error {
 	return nil
 }
 
+func (a *astLet) resolve(mgr *typMgr) error {
+	for _, b := range a.bindings {
+		err := resolveCommon(b.expr, mgr)
+		if err != nil {
+			return errors.Wrap(err, "resolve astLet binding")
+		}
+	}
+
+	err := resolveCommon(a.body, mgr)
+	if err != nil {
+		return errors.Wrap(err, "resolve astLet body")
+	}
+
+	return nil
+}
+
+func (a *astLambda) resolve(mgr *typMgr) error {
+	err := resolveCommon(a.body, mgr)
+	if err != nil {
+		return errors.Wrap(err, "resolve astLambda")
+	}
+
+	return nil
+}
+
+func (a *astIf) resolve(mgr *typMgr) error {
+	err := resolveCommon(a.cond, mgr)
+	if err != nil {
+		return errors.Wrap(err, "resolve astIf condition")
+	}
+
+	err = resolveCommon(a.thenExpr, mgr)
+	if err != nil {
+		return errors.Wrap(err, "resolve astIf then")
+	}
+
+	err = resolveCommon(a.elseExpr, mgr)
+	if err != nil {
+		return errors.Wrap(err, "resolve astIf else")
+	}
+
+	return nil
+}
+
 func (b *branch) resolve(mgr *typMgr) error {
 	err := resolveCommon(b.expr, mgr)
 	if err != nil {
@@ -100,15 +141,8 @@ func (a *definitionDefn) resolve(mgr *typMgr) error {
 
 	a.returnType = mgr.resolve(a.returnType, &v)
 
-	if v != nil {
-		return fmt.Errorf("Ambiguously typed program in resolve")
-	}
-
 	for i := 0; i < len(a.paramTypes); i++ {
 		a.paramTypes[i] = mgr.resolve(a.paramTypes[i], &v)
-		if v != nil {
-			return fmt.Errorf("Ambiguously typed program in resolve")
-		}
 	}
 
 	return nil
@@ -8,22 +8,15 @@ func (d definitionDefn) String() string {
 }
 
 func (d definitionData) String() string {
-	return fmt.Sprintf("data %s [%v]", d.name, d.constructors)
+	return fmt.Sprintf("data %s %v [%v]", d.name, d.params, d.constructors)
 }
 
 func (a astBinOp) String() string {
-	switch a.op {
-	case binOpPlus:
-		return fmt.Sprintf("%v + %v", a.left, a.right)
-	case binOpMinus:
-		return fmt.Sprintf("%v - %v", a.left, a.right)
-	case binOpTimes:
-		return fmt.Sprintf("%v * %v", a.left, a.right)
-	case binOpDivide:
-		return fmt.Sprintf("%v / %v", a.left, a.right)
-	default:
-		panic(fmt.Errorf("Unsupported Operator: %d", a.op))
+	o, err := opName(a.op)
+	if err != nil {
+		panic(err)
 	}
+	return fmt.Sprintf("%v %s %v", a.left, o, a.right)
 }
 
 func (a astInt) String() string {
@@ -61,3 +54,23 @@ func (c constructor) String() string {
 func (a astCase) String() string {
 	return fmt.Sprintf("case %v of { %v }", a.of, a.branches)
 }
+
+func (b letBinding) String() string {
+	return fmt.Sprintf("%s = %v", b.name, b.expr)
+}
+
+func (a astLet) String() string {
+	keyword := "let"
+	if a.rec {
+		keyword = "letrec"
+	}
+	return fmt.Sprintf("%s %v in %v", keyword, a.bindings, a.body)
+}
+
+func (a astLambda) String() string {
+	return fmt.Sprintf("\\%v -> %v", a.params, a.body)
+}
+
+func (a astIf) String() string {
+	return fmt.Sprintf("if %v then %v else %v", a.cond, a.thenExpr, a.elseExpr)
+}
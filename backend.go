@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Globals and printers of a program compiled by one of the backends.
+// Globals are referred to by their index in the runtime.
+type backend struct {
+	globals []global
+	index   map[string]int
+	plan    *printerPlan
+}
+
+func newBackend(p *program, globals []global) *backend {
+	index := make(map[string]int)
+	for i, g := range globals {
+		index[g.name] = i
+	}
+
+	return &backend{globals, index, newPrinterPlan(p)}
+}
+
+func (b *backend) global(name string) (int, error) {
+	i, ok := b.index[name]
+	if !ok {
+		return 0, fmt.Errorf("Undefined function: %s", name)
+	}
+
+	return i, nil
+}
+
+// Indices of the entry point and its argument globals
+func (b *backend) entry(entry *entryPoint) (int, []int, error) {
+	e, err := b.global(entry.name)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	args := make([]int, 0)
+	for _, arg := range entry.args {
+		a, err := b.global(arg)
+		if err != nil {
+			return 0, nil, err
+		}
+		args = append(args, a)
+	}
+
+	return e, args, nil
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Translates the G-code of a program to C. Every global becomes a function
+// which runs its instructions against the runtime in backend_c_runtime.go.
+type cGenerator struct {
+	*backend
+	out *strings.Builder
+}
+
+func generateC(p *program, globals []global, depth int) (string, error) {
+	gen := &cGenerator{newBackend(p, globals), &strings.Builder{}}
+	result := gen.plan.printerFor(p.entry.typ)
+
+	gen.printf("/* Generated by fngo build */\n")
+	gen.printf("#define RT static\n")
+	gen.printf("%s\n", cRuntime)
+
+	for i, g := range globals {
+		gen.printf("static void code_%d(void); /* %s */\n", i, g.name)
+	}
+	gen.printf("\n")
+	gen.printf("static const global_info globals[] = {\n")
+	for i, g := range globals {
+		gen.printf("\t{%s, %d, code_%d},\n", cString(g.name), g.arity, i)
+	}
+	gen.printf("};\n\n")
+
+	for i, g := range globals {
+		gen.printf("static void code_%d(void) {\n", i)
+		err := gen.code(g.code, "\t")
+		if err != nil {
+			return "", errors.Wrapf(err, "In %s", g.name)
+		}
+		gen.printf("}\n\n")
+	}
+
+	gen.plan.writeTables(gen)
+
+	err := gen.main(p.entry, result, depth)
+	if err != nil {
+		return "", err
+	}
+
+	return gen.out.String(), nil
+}
+
+func (gen *cGenerator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(gen.out, format, args...)
+}
+
+func (gen *cGenerator) code(code []inst, indent string) error {
+	for _, i := range code {
+		switch i := i.(type) {
+		case instPushInt:
+			gen.printf("%srt_pushint(%dLL);\n", indent, i.value)
+		case instPushGlobal:
+			g, err := gen.global(i.name)
+			if err != nil {
+				return err
+			}
+			gen.printf("%srt_push_global(%d);\n", indent, g)
+		case instPush:
+			gen.printf("%srt_push(%d);\n", indent, i.offset)
+		case instPop:
+			gen.printf("%srt_pop(%d);\n", indent, i.count)
+		case instMkApp:
+			gen.printf("%srt_mkapp();\n", indent)
+		case instUpdate:
+			gen.printf("%srt_update(%d);\n", indent, i.offset)
+		case instPack:
+			gen.printf("%srt_pack(%d, %d);\n", indent, i.tag, i.size)
+		case instSplit:
+			gen.printf("%srt_split();\n", indent)
+		case instJump:
+			err := gen.jump(i, indent)
+			if err != nil {
+				return err
+			}
+		case instSlide:
+			gen.printf("%srt_slide(%d);\n", indent, i.offset)
+		case instBinOp:
+			gen.printf("%srt_binop(%d, %s);\n", indent, i.op, cString(i.pos.String()))
+		case instEval:
+			gen.printf("%srt_eval();\n", indent)
+		case instAlloc:
+			gen.printf("%srt_alloc_holes(%d);\n", indent, i.amount)
+		case instUnwind:
+			gen.printf("%sreturn;\n", indent)
+		default:
+			return fmt.Errorf("Unknown instruction: %v", i)
+		}
+	}
+
+	return nil
+}
+
+// Branches are selected with a switch on the tag, and execution continues
+// after the Jump once a branch is done
+func (gen *cGenerator) jump(i instJump, indent string) error {
+	gen.printf("%sswitch (rt_tag()) {\n", indent)
+	for b, branch := range i.branches {
+		for _, tag := range sortedTags(i.tagMappings, b) {
+			gen.printf("%scase %d:\n", indent, tag)
+		}
+		gen.printf("%s\t{\n", indent)
+		err := gen.code(branch, indent+"\t\t")
+		if err != nil {
+			return err
+		}
+		gen.printf("%s\t}\n", indent)
+		gen.printf("%s\tbreak;\n", indent)
+	}
+	gen.printf("%sdefault:\n", indent)
+	gen.printf("%s\trt_no_branch(rt_tag());\n", indent)
+	gen.printf("%s}\n", indent)
+
+	return nil
+}
+
+// Tags which select branch b, in increasing order
+func sortedTags(tagMappings map[int]int, b int) []int {
+	tags := make([]int, 0)
+	for tag, target := range tagMappings {
+		if target == b {
+			tags = append(tags, tag)
+		}
+	}
+	sort.Ints(tags)
+
+	return tags
+}
+
+func (gen *cGenerator) null() string {
+	return "NULL"
+}
+
+func (gen *cGenerator) fieldsArray(printer int, tag int, fields []int) string {
+	name := fmt.Sprintf("fields_%d_%d", printer, tag)
+	gen.printf("static const int %s[] = {%s};\n", name, joinInts(fields))
+
+	return name
+}
+
+func (gen *cGenerator) constructorEntry(c printerConstructor, fields string) string {
+	return fmt.Sprintf("{%d, %s, %d, %s}", c.tag, cString(c.name), len(c.fields), fields)
+}
+
+func (gen *cGenerator) constructorArray(printer int, entries []string) string {
+	name := fmt.Sprintf("constructors_%d", printer)
+	gen.printf("static const printer_constructor %s[] = {\n\t%s,\n};\n", name, strings.Join(entries, ",\n\t"))
+
+	return name
+}
+
+func (gen *cGenerator) printerEntry(s printerSpec, constructors string) string {
+	return fmt.Sprintf("{%d, %d, %s}", s.kind, len(s.constructors), constructors)
+}
+
+func (gen *cGenerator) printerArray(entries []string) {
+	gen.printf("static const printer_info printer_table[] = {\n\t%s,\n};\n\n", strings.Join(entries, ",\n\t"))
+}
+
+func (gen *cGenerator) main(entry *entryPoint, result int, depth int) error {
+	e, args, err := gen.entry(entry)
+	if err != nil {
+		return err
+	}
+
+	gen.printf("int main(void) {\n")
+	if len(args) > 0 {
+		gen.printf("\tstatic const int args[] = {%s};\n", joinInts(args))
+	} else {
+		gen.printf("\tstatic const int *args = NULL;\n")
+	}
+	gen.printf("\trt_init(globals, %d);\n", len(gen.globals))
+	gen.printf("\treturn rt_run(%d, args, %d, printer_table, %d, %d);\n", e, len(args), result, depth)
+	gen.printf("}\n")
+
+	return nil
+}
+
+func joinInts(ns []int) string {
+	parts := make([]string, 0)
+	for _, n := range ns {
+		parts = append(parts, fmt.Sprintf("%d", n))
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// Quote s as a C string literal
+func cString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < ' ' || c > '~':
+			fmt.Fprintf(&b, "\\%03o", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+
+	return b.String()
+}
@@ -0,0 +1,557 @@
+package main
+
+// Runtime library of the C and LLVM backends. The generated code defines the
+// globals and the printer tables and calls the functions marked RT, which are
+// static when the runtime is included in generated C and external when it is
+// linked with LLVM IR. Nodes never move, and the collector treats the whole
+// stack and every global as roots, so node pointers stay valid as long as the
+// node is on the stack. The instructions therefore allocate before they pop
+// their operands.
+const cRuntime = `#include <limits.h>
+#include <stdarg.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+enum { N_APP, N_NUM, N_GLOBAL, N_IND, N_DATA, N_FREE };
+
+typedef struct node node;
+struct node {
+	unsigned char tag;
+	unsigned char marked;
+	union {
+		struct { node *left, *right; } app;
+		long long num;
+		struct { int arity; void (*code)(void); const char *name; } global;
+		node *ind;
+		struct { int tag; int size; node **fields; } data;
+		node *next_free;
+	} u;
+};
+
+typedef struct {
+	const char *name;
+	int arity;
+	void (*code)(void);
+} global_info;
+
+/* Printers of the result. Fields of a constructor are printed with the
+   printers at the given indices, or without a type if fields is NULL. */
+enum { P_INT, P_DATA, P_UNKNOWN };
+
+typedef struct {
+	int tag;
+	const char *name;
+	int size;
+	const int *fields;
+} printer_constructor;
+
+typedef struct {
+	int kind;
+	int count;
+	const printer_constructor *constructors;
+} printer_info;
+
+#define STACK_SIZE (1 << 22)
+/* Every Eval nests C calls, so evaluations are nested at most this deep to
+   stay well within a default 8MB C stack */
+#define EVAL_DEPTH (1 << 16)
+#define CHUNK_NODES (1 << 16)
+
+static node **stack_base, **sp, **fp;
+static const char *current = "";
+
+static void rt_fail(const char *format, ...) {
+	va_list args;
+	va_start(args, format);
+	if (*current) {
+		fprintf(stderr, "Runtime error in %s: ", current);
+	} else {
+		fprintf(stderr, "Runtime error: ");
+	}
+	vfprintf(stderr, format, args);
+	fprintf(stderr, "\n");
+	va_end(args);
+	exit(1);
+}
+
+static void *rt_malloc(size_t size) {
+	void *p = malloc(size ? size : 1);
+	if (!p) {
+		rt_fail("Out of memory");
+	}
+	return p;
+}
+
+static void push(node *n) {
+	if (sp == stack_base + STACK_SIZE) {
+		rt_fail("Stack overflow");
+	}
+	*sp++ = n;
+}
+
+/* Heap */
+
+typedef struct chunk {
+	struct chunk *next;
+	node nodes[CHUNK_NODES];
+} chunk;
+
+static chunk *chunks;
+static node *free_list;
+static size_t free_count, total_nodes;
+static node **G;
+static int nglobals;
+
+static void add_chunk(void) {
+	chunk *c = rt_malloc(sizeof(chunk));
+	int i;
+	c->next = chunks;
+	chunks = c;
+	for (i = 0; i < CHUNK_NODES; i++) {
+		c->nodes[i].tag = N_FREE;
+		c->nodes[i].marked = 0;
+		c->nodes[i].u.next_free = free_list;
+		free_list = &c->nodes[i];
+	}
+	free_count += CHUNK_NODES;
+	total_nodes += CHUNK_NODES;
+}
+
+static node **mark_stack;
+static size_t mark_size, mark_cap;
+
+static void mark_push(node *n) {
+	if (!n || n->marked) {
+		return;
+	}
+	if (mark_size == mark_cap) {
+		mark_cap = mark_cap ? 2 * mark_cap : 1024;
+		mark_stack = realloc(mark_stack, mark_cap * sizeof(node *));
+		if (!mark_stack) {
+			rt_fail("Out of memory");
+		}
+	}
+	n->marked = 1;
+	mark_stack[mark_size++] = n;
+}
+
+static void collect(void) {
+	node **p;
+	chunk *c;
+	int i;
+
+	for (p = stack_base; p < sp; p++) {
+		mark_push(*p);
+	}
+	for (i = 0; i < nglobals; i++) {
+		mark_push(G[i]);
+	}
+	while (mark_size > 0) {
+		node *n = mark_stack[--mark_size];
+		switch (n->tag) {
+		case N_APP:
+			mark_push(n->u.app.left);
+			mark_push(n->u.app.right);
+			break;
+		case N_IND:
+			mark_push(n->u.ind);
+			break;
+		case N_DATA:
+			for (i = 0; i < n->u.data.size; i++) {
+				mark_push(n->u.data.fields[i]);
+			}
+			break;
+		}
+	}
+
+	free_list = NULL;
+	free_count = 0;
+	for (c = chunks; c; c = c->next) {
+		for (i = 0; i < CHUNK_NODES; i++) {
+			node *n = &c->nodes[i];
+			if (n->marked) {
+				n->marked = 0;
+				continue;
+			}
+			if (n->tag == N_DATA) {
+				free(n->u.data.fields);
+			}
+			n->tag = N_FREE;
+			n->u.next_free = free_list;
+			free_list = n;
+			free_count++;
+		}
+	}
+}
+
+static node *rt_alloc(void) {
+	node *n;
+	if (!free_list) {
+		collect();
+		if (free_count < total_nodes / 2) {
+			add_chunk();
+		}
+	}
+	n = free_list;
+	free_list = n->u.next_free;
+	free_count--;
+	return n;
+}
+
+RT void rt_init(const global_info *globals, int n) {
+	int i;
+	stack_base = rt_malloc(STACK_SIZE * sizeof(node *));
+	sp = fp = stack_base;
+	add_chunk();
+
+	nglobals = n;
+	G = rt_malloc(n * sizeof(node *));
+	for (i = 0; i < n; i++) {
+		G[i] = NULL;
+	}
+	for (i = 0; i < n; i++) {
+		node *g = rt_alloc();
+		g->tag = N_GLOBAL;
+		g->u.global.arity = globals[i].arity;
+		g->u.global.code = globals[i].code;
+		g->u.global.name = globals[i].name;
+		G[i] = g;
+	}
+}
+
+/* Instructions. Offsets count from the top of the stack, as in compEnv. */
+
+RT void rt_push(int offset) {
+	push(sp[-1 - offset]);
+}
+
+RT void rt_pop(int n) {
+	sp -= n;
+}
+
+RT void rt_push_global(int i) {
+	push(G[i]);
+}
+
+RT void rt_pushint(long long v) {
+	node *n = rt_alloc();
+	n->tag = N_NUM;
+	n->u.num = v;
+	push(n);
+}
+
+RT void rt_mkapp(void) {
+	node *n = rt_alloc();
+	n->tag = N_APP;
+	n->u.app.left = sp[-1];
+	n->u.app.right = sp[-2];
+	sp -= 2;
+	push(n);
+}
+
+/* Apply the node on top of the stack to a global */
+static void rt_apply(node *arg) {
+	node *n = rt_alloc();
+	n->tag = N_APP;
+	n->u.app.left = sp[-1];
+	n->u.app.right = arg;
+	sp[-1] = n;
+}
+
+/* An indirection chain from a back to target, as letrec x = x makes, would
+   loop when unwound, so target is left a hole instead */
+RT void rt_update(int offset) {
+	node *a = sp[-1];
+	node *target = sp[-2 - offset];
+	node *n;
+	sp--;
+	for (n = a; n; n = n->tag == N_IND ? n->u.ind : NULL) {
+		if (n == target) {
+			a = NULL;
+			break;
+		}
+	}
+	if (target->tag == N_DATA) {
+		free(target->u.data.fields);
+	}
+	target->tag = N_IND;
+	target->u.ind = a;
+}
+
+RT void rt_pack(int tag, int size) {
+	node *n = rt_alloc();
+	int i;
+	n->tag = N_DATA;
+	n->u.data.tag = tag;
+	n->u.data.size = size;
+	n->u.data.fields = size ? rt_malloc(size * sizeof(node *)) : NULL;
+	for (i = 0; i < size; i++) {
+		n->u.data.fields[i] = sp[-1 - i];
+	}
+	sp -= size;
+	push(n);
+}
+
+static node *rt_expect_data(node *n) {
+	if (n->tag != N_DATA) {
+		rt_fail("Expected data node");
+	}
+	return n;
+}
+
+RT void rt_split(void) {
+	node *n = rt_expect_data(sp[-1]);
+	int i;
+	sp--;
+	for (i = n->u.data.size - 1; i >= 0; i--) {
+		push(n->u.data.fields[i]);
+	}
+}
+
+RT int rt_tag(void) {
+	return rt_expect_data(sp[-1])->u.data.tag;
+}
+
+RT void rt_no_branch(int tag) {
+	rt_fail("No branch for tag %d", tag);
+}
+
+RT void rt_slide(int n) {
+	node *a = sp[-1];
+	sp -= n + 1;
+	push(a);
+}
+
+RT void rt_alloc_holes(int n) {
+	int i;
+	for (i = 0; i < n; i++) {
+		node *h = rt_alloc();
+		h->tag = N_IND;
+		h->u.ind = NULL;
+		push(h);
+	}
+}
+
+enum { OP_PLUS, OP_MINUS, OP_TIMES, OP_DIVIDE, OP_EQ, OP_NE, OP_LT, OP_LE, OP_GT, OP_GE };
+
+/* Integers wrap around like Go's int */
+RT void rt_binop(int op, const char *pos) {
+	node *l = sp[-1], *r = sp[-2], *n;
+	unsigned long long a, b;
+	long long result = 0;
+	int cmp = -1;
+	if (l->tag != N_NUM || r->tag != N_NUM) {
+		rt_fail("%s: Not a number", pos);
+	}
+	a = (unsigned long long)l->u.num;
+	b = (unsigned long long)r->u.num;
+	switch (op) {
+	case OP_PLUS:
+		result = (long long)(a + b);
+		break;
+	case OP_MINUS:
+		result = (long long)(a - b);
+		break;
+	case OP_TIMES:
+		result = (long long)(a * b);
+		break;
+	case OP_DIVIDE:
+		if (r->u.num == 0) {
+			rt_fail("%s: Division by zero", pos);
+		}
+		if (l->u.num == LLONG_MIN && r->u.num == -1) {
+			result = LLONG_MIN;
+		} else {
+			result = l->u.num / r->u.num;
+		}
+		break;
+	case OP_EQ:
+		cmp = l->u.num == r->u.num;
+		break;
+	case OP_NE:
+		cmp = l->u.num != r->u.num;
+		break;
+	case OP_LT:
+		cmp = l->u.num < r->u.num;
+		break;
+	case OP_LE:
+		cmp = l->u.num <= r->u.num;
+		break;
+	case OP_GT:
+		cmp = l->u.num > r->u.num;
+		break;
+	case OP_GE:
+		cmp = l->u.num >= r->u.num;
+		break;
+	}
+
+	n = rt_alloc();
+	if (cmp >= 0) {
+		n->tag = N_DATA;
+		n->u.data.tag = cmp;
+		n->u.data.size = 0;
+		n->u.data.fields = NULL;
+	} else {
+		n->tag = N_NUM;
+		n->u.num = result;
+	}
+	sp -= 2;
+	push(n);
+}
+
+/* Code of a global ends in Unwind, which returns to this loop */
+static void rt_unwind(void) {
+	for (;;) {
+		node *n = sp[-1];
+		int i;
+		switch (n->tag) {
+		case N_APP:
+			push(n->u.app.left);
+			break;
+		case N_IND:
+			if (!n->u.ind) {
+				rt_fail("Value depends on itself");
+			}
+			sp[-1] = n->u.ind;
+			break;
+		case N_GLOBAL:
+			if (sp - fp <= n->u.global.arity) {
+				/* A partial application is in WHNF, its value is the root */
+				sp = fp + 1;
+				return;
+			}
+			for (i = 1; i <= n->u.global.arity; i++) {
+				node *app = sp[-1 - i];
+				if (app->tag != N_APP) {
+					rt_fail("Expected application node for argument %d of %s", i, n->u.global.name);
+				}
+				sp[-i] = app->u.app.right;
+			}
+			current = n->u.global.name;
+			n->u.global.code();
+			break;
+		default:
+			return;
+		}
+	}
+}
+
+static int eval_depth;
+
+/* The dump is the C stack: a frame starts at the node being evaluated */
+RT void rt_eval(void) {
+	node **saved_fp = fp;
+	const char *saved_current = current;
+	node *a;
+	if (++eval_depth > EVAL_DEPTH) {
+		rt_fail("Stack overflow: evaluation nested deeper than %d", EVAL_DEPTH);
+	}
+	fp = sp - 1;
+	rt_unwind();
+	a = sp[-1];
+	sp = fp;
+	push(a);
+	fp = saved_fp;
+	current = saved_current;
+	eval_depth--;
+}
+
+/* Printing */
+
+static const printer_info *printers;
+static int max_depth;
+
+static char *rt_strdup(const char *s) {
+	char *d = rt_malloc(strlen(s) + 1);
+	strcpy(d, s);
+	return d;
+}
+
+static char *rt_print(int p, node *n, int depth);
+
+/* The name followed by the fields, in a buffer grown as they are printed. A
+   field goes in parentheses when its text has a space or a leading '-'. */
+static char *rt_show_data(const char *name, node *n, int depth, const int *fields) {
+	size_t len = strlen(name), cap = len + 1;
+	char *s = rt_malloc(cap);
+	int i;
+	strcpy(s, name);
+	for (i = 0; i < n->u.data.size; i++) {
+		char *f = rt_print(fields ? fields[i] : -1, n->u.data.fields[i], depth + 1);
+		size_t flen = strlen(f);
+		int paren = strchr(f, ' ') != NULL || f[0] == '-';
+		size_t need = len + flen + 4;
+		if (need > cap) {
+			cap = 2 * need;
+			s = realloc(s, cap);
+			if (!s) {
+				rt_fail("Out of memory");
+			}
+		}
+		len += sprintf(s + len, paren ? " (%s)" : " %s", f);
+		free(f);
+	}
+	return s;
+}
+
+/* Print n with printer p, or without a type if p is negative, into a string
+   the caller frees. n is pushed for the duration, as a root for the
+   collections evaluating its fields may run. */
+static char *rt_print(int p, node *n, int depth) {
+	char buf[32];
+	char *s = NULL;
+	int i;
+	push(n);
+	rt_eval();
+	n = sp[-1];
+
+	switch (n->tag) {
+	case N_NUM:
+		snprintf(buf, sizeof(buf), "%lld", n->u.num);
+		s = rt_strdup(buf);
+		break;
+	case N_DATA:
+		if (depth >= max_depth && n->u.data.size > 0) {
+			s = rt_strdup("...");
+			break;
+		}
+		if (p >= 0 && printers[p].kind == P_DATA) {
+			for (i = 0; i < printers[p].count; i++) {
+				const printer_constructor *c = &printers[p].constructors[i];
+				if (c->tag == n->u.data.tag && c->size == n->u.data.size) {
+					s = rt_show_data(c->name, n, depth, c->fields);
+					break;
+				}
+			}
+		}
+		if (!s) {
+			snprintf(buf, sizeof(buf), "<tag %d>", n->u.data.tag);
+			s = rt_show_data(buf, n, depth, NULL);
+		}
+		break;
+	default:
+		s = rt_strdup("<function>");
+	}
+
+	sp--;
+	return s;
+}
+
+/* Body of main: build the application of G[entry] to the globals in args
+   and print its value with printer result */
+RT int rt_run(int entry, const int *args, int nargs, const printer_info *table, int result, int depth) {
+	char *s;
+	int i;
+	printers = table;
+	max_depth = depth;
+	push(G[entry]);
+	for (i = 0; i < nargs; i++) {
+		rt_apply(G[args[i]]);
+	}
+	s = rt_print(result, sp[-1], 0);
+	puts(s);
+	free(s);
+	return 0;
+}
+`
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// Skip the test unless every tool is installed
+func requireTools(t *testing.T, tools ...string) {
+	for _, tool := range tools {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s is not installed: %v", tool, err)
+		}
+	}
+}
+
+// Compile the program at path, with the arguments of its entry point main
+func compileFile(t testing.TB, path string, args []string) *program {
+	p, err := checkFile(path)
+	if err == nil {
+		err = p.setEntry("main", args)
+	}
+	if err == nil {
+		err = p.compile()
+	}
+	if err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+
+	return p
+}
+
+// Build every program of the corpus for the target, run it with the command
+// run returns and compare what it prints with the result of gVM. A runtime
+// error must be reported with the same message.
+func testBackend(t *testing.T, target buildTarget, run func(output string) *exec.Cmd) {
+	opts := &commandOptions{cc: "gcc", llc: "llc", wat2wasm: "wat2wasm"}
+	for _, path := range corpus(t) {
+		p := compileFile(t, path, nil)
+		globals := programGlobals(p.defs)
+		result, _, err := runProgram(p, globals, runOptions{traceResult, overflowWrap, 8, interpDense})
+		want := outcome(result, err)
+
+		got, err := buildAndRun(opts, target, p, globals, run)
+		if err != nil {
+			t.Errorf("%s: %v", path, err)
+			continue
+		}
+		if strings.HasPrefix(want, "error: ") {
+			if !strings.HasPrefix(got, "error: ") || !strings.Contains(got, strings.TrimPrefix(want, "error: ")) {
+				t.Errorf("%s:\n\tgot:  %s\n\twant: %s", path, got, want)
+			}
+		} else if got != want {
+			t.Errorf("%s:\n\tgot:  %s\n\twant: %s", path, got, want)
+		}
+	}
+}
+
+// The printed result, or what the program wrote to stderr if it failed
+func buildAndRun(opts *commandOptions, target buildTarget, p *program, globals []global, run func(output string) *exec.Cmd) (string, error) {
+	var source string
+	var err error
+	switch target {
+	case targetLLVM:
+		source, err = generateLLVM(p, globals, 8)
+	case targetWAT:
+		source, err = generateWAT(p, globals, 8)
+	default:
+		source, err = generateC(p, globals, 8)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	dir, err := ioutil.TempDir("", "fngo")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+
+	output := filepath.Join(dir, "main")
+	switch target {
+	case targetLLVM:
+		err = buildLLVM(opts, dir, source, output)
+	case targetWAT:
+		err = buildWAT(opts, dir, source, output)
+	default:
+		err = buildC(opts, dir, source, output)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := run(output)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if _, ok := err.(*exec.ExitError); ok {
+		return "error: " + strings.TrimSpace(stderr.String()), nil
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "running the program")
+	}
+
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+func TestBackendC(t *testing.T) {
+	requireTools(t, "gcc")
+	testBackend(t, targetC, func(output string) *exec.Cmd {
+		return exec.Command(output)
+	})
+}
+
+// Sums a list without a tail call, so evaluations nest as deep as the list
+// is long
+const deepSum = `
+data List a = { Nil, Cons a (List a) }
+defn range n = { if n == 0 then Nil else Cons n (range (n - 1)) }
+defn sum l = { case l of { Nil -> { 0 } Cons x xs -> { x + sum xs } } }
+defn main n = { sum (range n) }
+`
+
+// Build the program src for the target, with the arguments of its entry
+// point main, and return what it prints
+func buildSource(t *testing.T, target buildTarget, src string, args []string, run func(output string) *exec.Cmd) string {
+	dir, err := ioutil.TempDir("", "fngo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "main.fn")
+	err = ioutil.WriteFile(path, []byte(src), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := compileFile(t, path, args)
+	opts := &commandOptions{cc: "gcc", llc: "llc", wat2wasm: "wat2wasm"}
+	got, err := buildAndRun(opts, target, p, programGlobals(p.defs), run)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return got
+}
+
+// Nested evaluation is bounded, so it fails cleanly instead of overflowing
+// the C stack
+func TestBackendCEvalDepth(t *testing.T) {
+	requireTools(t, "gcc")
+	got := buildSource(t, targetC, deepSum, []string{"1000000"}, func(output string) *exec.Cmd {
+		return exec.Command(output)
+	})
+	if !strings.Contains(got, "Stack overflow: evaluation nested deeper than") {
+		t.Errorf("got %q, want a stack overflow", got)
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"go/format"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Import path of the package compiled programs run on
+const goRuntimePath = "github.com/pocket7878/fngo/rt"
+
+// Names of the operators in the rt package, by binOpType
+var goOps = []string{
+	"rt.OpPlus", "rt.OpMinus", "rt.OpTimes", "rt.OpDivide",
+	"rt.OpEq", "rt.OpNe", "rt.OpLt", "rt.OpLe", "rt.OpGt", "rt.OpGe",
+}
+
+var goPrinterKinds = []string{"rt.PrinterInt", "rt.PrinterData", "rt.PrinterUnknown"}
+
+// Translates the G-code of a program to a Go package. Every global becomes a
+// function calling the instructions of rt.Machine, and the package exports
+// Run, which prints the value of the entry point. A main package also gets
+// a main function, so it builds to an executable.
+type goGenerator struct {
+	*backend
+	out *strings.Builder
+	// Set after an Unwind, when following code would be unreachable
+	terminated bool
+}
+
+func generateGo(p *program, globals []global, depth int, pkg string) (string, error) {
+	gen := &goGenerator{newBackend(p, globals), &strings.Builder{}, false}
+	result := gen.plan.printerFor(p.entry.typ)
+
+	gen.printf("// Code generated by fngo gen-go. DO NOT EDIT.\n\n")
+	gen.printf("package %s\n\n", pkg)
+	gen.printf("import (\n")
+	if pkg == "main" {
+		gen.printf("\"fmt\"\n\"os\"\n\n")
+	}
+	gen.printf("%q\n)\n\n", goRuntimePath)
+
+	gen.printf("var globals = []rt.Global{\n")
+	for i, g := range globals {
+		gen.printf("{Name: %q, Arity: %d, Code: code%d},\n", g.name, g.arity, i)
+	}
+	gen.printf("}\n\n")
+
+	for i, g := range globals {
+		gen.printf("// %s\n", strings.Replace(g.name, "\n", " ", -1))
+		gen.printf("func code%d(m *rt.Machine) {\n", i)
+		gen.terminated = false
+		err := gen.code(g.code, true)
+		if err != nil {
+			return "", errors.Wrapf(err, "In %s", g.name)
+		}
+		gen.printf("}\n\n")
+	}
+
+	gen.printers()
+
+	err := gen.run(p.entry, result, depth, pkg == "main")
+	if err != nil {
+		return "", err
+	}
+
+	source, err := format.Source([]byte(gen.out.String()))
+	if err != nil {
+		return "", errors.Wrap(err, "Generated invalid Go")
+	}
+
+	return string(source), nil
+}
+
+func (gen *goGenerator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(gen.out, format, args...)
+}
+
+// An Unwind at the end of the code of a global needs no return
+func (gen *goGenerator) code(code []inst, last bool) error {
+	for n, i := range code {
+		if gen.terminated {
+			break
+		}
+		switch i := i.(type) {
+		case instPushInt:
+			gen.printf("m.PushInt(%d)\n", i.value)
+		case instPushGlobal:
+			g, err := gen.global(i.name)
+			if err != nil {
+				return err
+			}
+			gen.printf("m.PushGlobal(%d)\n", g)
+		case instPush:
+			gen.printf("m.Push(%d)\n", i.offset)
+		case instPop:
+			gen.printf("m.Pop(%d)\n", i.count)
+		case instMkApp:
+			gen.printf("m.MkApp()\n")
+		case instUpdate:
+			gen.printf("m.Update(%d)\n", i.offset)
+		case instPack:
+			gen.printf("m.Pack(%d, %d)\n", i.tag, i.size)
+		case instSplit:
+			gen.printf("m.Split()\n")
+		case instJump:
+			err := gen.jump(i)
+			if err != nil {
+				return err
+			}
+		case instSlide:
+			gen.printf("m.Slide(%d)\n", i.offset)
+		case instBinOp:
+			if i.op < 0 || i.op >= len(goOps) {
+				return fmt.Errorf("Unsupported BinOp: %d", i.op)
+			}
+			gen.printf("m.BinOp(%s, %q)\n", goOps[i.op], i.pos.String())
+		case instEval:
+			gen.printf("m.Eval()\n")
+		case instAlloc:
+			gen.printf("m.Alloc(%d)\n", i.amount)
+		case instUnwind:
+			if !last || n < len(code)-1 {
+				gen.printf("return\n")
+			}
+			gen.terminated = true
+		default:
+			return fmt.Errorf("Unknown instruction: %v", i)
+		}
+	}
+
+	return nil
+}
+
+// A switch on m.Tag() with a case listing the tags of each branch. A branch
+// ending in Unwind returns from the function, the others fall out of the
+// switch into the code after the Jump, and a tag without a branch goes to
+// NoBranch in the default case.
+func (gen *goGenerator) jump(i instJump) error {
+	gen.printf("switch tag := m.Tag(); tag {\n")
+	for b, branch := range i.branches {
+		tags := sortedTags(i.tagMappings, b)
+		if len(tags) == 0 {
+			continue
+		}
+		gen.printf("case %s:\n", joinInts(tags))
+		gen.terminated = false
+		err := gen.code(branch, false)
+		if err != nil {
+			return err
+		}
+	}
+	gen.printf("default:\n")
+	gen.printf("m.NoBranch(tag)\n")
+	gen.printf("}\n")
+	gen.terminated = false
+
+	return nil
+}
+
+// Printer tables for rt.Machine.Run
+func (gen *goGenerator) printers() {
+	gen.printf("var printers = []rt.Printer{\n")
+	for _, s := range gen.plan.printers {
+		if len(s.constructors) == 0 {
+			gen.printf("{Kind: %s},\n", goPrinterKinds[s.kind])
+			continue
+		}
+		gen.printf("{Kind: %s, Constructors: []rt.Constructor{\n", goPrinterKinds[s.kind])
+		for _, c := range s.constructors {
+			if len(c.fields) == 0 {
+				gen.printf("{Tag: %d, Name: %q},\n", c.tag, c.name)
+				continue
+			}
+			gen.printf("{Tag: %d, Name: %q, Fields: []int{%s}},\n", c.tag, c.name, joinInts(c.fields))
+		}
+		gen.printf("}},\n")
+	}
+	gen.printf("}\n\n")
+}
+
+func (gen *goGenerator) run(entry *entryPoint, result int, depth int, main bool) error {
+	e, args, err := gen.entry(entry)
+	if err != nil {
+		return err
+	}
+
+	applied := ""
+	if len(args) > 0 {
+		applied = " applied to its arguments"
+	}
+	gen.printf("// Run evaluates %s%s and prints its value\n", entry.name, applied)
+	gen.printf("func Run() (string, error) {\n")
+	gen.printf("return rt.NewMachine(globals).Run(%d, []int{%s}, printers, %d, %d)\n", e, joinInts(args), result, depth)
+	gen.printf("}\n")
+
+	if main {
+		gen.printf("\nfunc main() {\n")
+		gen.printf("s, err := Run()\n")
+		gen.printf("if err != nil {\n")
+		gen.printf("fmt.Fprintln(os.Stderr, \"Runtime Error:\", err)\n")
+		gen.printf("os.Exit(1)\n")
+		gen.printf("}\n")
+		gen.printf("fmt.Println(s)\n")
+		gen.printf("}\n")
+	}
+
+	return nil
+}
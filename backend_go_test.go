@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// Build every program of the corpus as a main package and check that it
+// writes what fngo run does, a runtime error included. The package is
+// written inside the module, so that it finds the rt package, in a
+// directory go ./... leaves out.
+func TestBackendGo(t *testing.T) {
+	requireTools(t, "go")
+
+	dir, err := ioutil.TempDir(".", "_gotest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, path := range corpus(t) {
+		p := compileFile(t, path, nil)
+		globals := programGlobals(p.defs)
+		result, _, err := runProgram(p, globals, runOptions{traceResult, overflowWrap, 8, interpDense})
+		wantOut, wantErr := result+"\n", ""
+		if err != nil {
+			wantOut, wantErr = "", "Runtime Error: "+err.Error()+"\n"
+		}
+
+		source, err := generateGo(p, globals, 8, "main")
+		if err != nil {
+			t.Errorf("%s: %v", path, err)
+			continue
+		}
+		err = ioutil.WriteFile(filepath.Join(dir, "main.go"), []byte(source), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		output := filepath.Join(dir, "main")
+		build, err := exec.Command("go", "build", "-o", output, "./"+filepath.Base(dir)).CombinedOutput()
+		if err != nil {
+			t.Fatalf("%s: %v\n%s", path, err, build)
+		}
+
+		var stdout, stderr bytes.Buffer
+		cmd := exec.Command(output)
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		err = cmd.Run()
+		if _, ok := err.(*exec.ExitError); err != nil && !ok {
+			t.Fatal(err)
+		}
+		if stdout.String() != wantOut || stderr.String() != wantErr {
+			t.Errorf("%s:\n\tgot:  %q %q\n\twant: %q %q", path, stdout.String(), stderr.String(), wantOut, wantErr)
+		}
+	}
+}
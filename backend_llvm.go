@@ -0,0 +1,282 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Runtime to link with the LLVM IR of a program
+const llvmRuntime = "#define RT\n" + cRuntime
+
+// The IR uses opaque pointers, which LLVM 15 and later read by default and
+// LLVM 14 reads with -opaque-pointers. Older versions are not supported.
+const llvmHeader = `%global_info = type { ptr, i32, ptr }
+%printer_constructor = type { i32, ptr, i32, ptr }
+%printer_info = type { i32, i32, ptr }
+
+declare void @rt_init(ptr, i32)
+declare i32 @rt_run(i32, ptr, i32, ptr, i32, i32)
+declare void @rt_push(i32)
+declare void @rt_pop(i32)
+declare void @rt_push_global(i32)
+declare void @rt_pushint(i64)
+declare void @rt_mkapp()
+declare void @rt_update(i32)
+declare void @rt_pack(i32, i32)
+declare void @rt_split()
+declare i32 @rt_tag()
+declare void @rt_no_branch(i32) noreturn
+declare void @rt_slide(i32)
+declare void @rt_binop(i32, ptr)
+declare void @rt_eval()
+declare void @rt_alloc_holes(i32)
+`
+
+// Translates the G-code of a program to LLVM IR. Like the C backend, every
+// global becomes a function calling into the runtime, with the stack
+// offsets of compEnv passed through unchanged.
+type llvmGenerator struct {
+	*backend
+	out    *strings.Builder
+	consts *strings.Builder
+	// Number of string constants and of jumps so far, for unique names
+	strs  int
+	jumps int
+	// Set after a terminator, when following code would be unreachable
+	terminated bool
+}
+
+func generateLLVM(p *program, globals []global, depth int) (string, error) {
+	gen := &llvmGenerator{newBackend(p, globals), &strings.Builder{}, &strings.Builder{}, 0, 0, false}
+	result := gen.plan.printerFor(p.entry.typ)
+
+	gen.printf("; Generated by fngo build -target llvm\n\n")
+	gen.printf("%s\n", llvmHeader)
+
+	for i, g := range globals {
+		gen.printf("; %s\n", strings.Replace(g.name, "\n", " ", -1))
+		gen.printf("define internal void @code_%d() {\n", i)
+		gen.printf("entry:\n")
+		gen.terminated = false
+		err := gen.code(g.code)
+		if err != nil {
+			return "", errors.Wrapf(err, "In %s", g.name)
+		}
+		if !gen.terminated {
+			gen.inst("ret void")
+		}
+		gen.printf("}\n\n")
+	}
+
+	entries := make([]string, 0)
+	for i, g := range globals {
+		entries = append(entries, fmt.Sprintf("%%global_info { ptr %s, i32 %d, ptr @code_%d }", gen.str(g.name), g.arity, i))
+	}
+	gen.printf("@globals = private constant %s\n\n", llvmArray("%global_info", entries))
+
+	gen.plan.writeTables(gen)
+
+	err := gen.main(p.entry, result, depth)
+	if err != nil {
+		return "", err
+	}
+
+	return gen.out.String() + "\n" + gen.consts.String(), nil
+}
+
+func (gen *llvmGenerator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(gen.out, format, args...)
+}
+
+// Emit an instruction into the current block, starting an unreachable one
+// after a terminator
+func (gen *llvmGenerator) inst(format string, args ...interface{}) {
+	if gen.terminated {
+		gen.jumps++
+		gen.label(fmt.Sprintf("dead.%d", gen.jumps))
+	}
+	gen.printf("\t"+format+"\n", args...)
+}
+
+func (gen *llvmGenerator) label(name string) {
+	gen.printf("%s:\n", name)
+	gen.terminated = false
+}
+
+func (gen *llvmGenerator) code(code []inst) error {
+	for _, i := range code {
+		switch i := i.(type) {
+		case instPushInt:
+			gen.inst("call void @rt_pushint(i64 %d)", i.value)
+		case instPushGlobal:
+			g, err := gen.global(i.name)
+			if err != nil {
+				return err
+			}
+			gen.inst("call void @rt_push_global(i32 %d)", g)
+		case instPush:
+			gen.inst("call void @rt_push(i32 %d)", i.offset)
+		case instPop:
+			gen.inst("call void @rt_pop(i32 %d)", i.count)
+		case instMkApp:
+			gen.inst("call void @rt_mkapp()")
+		case instUpdate:
+			gen.inst("call void @rt_update(i32 %d)", i.offset)
+		case instPack:
+			gen.inst("call void @rt_pack(i32 %d, i32 %d)", i.tag, i.size)
+		case instSplit:
+			gen.inst("call void @rt_split()")
+		case instJump:
+			err := gen.jump(i)
+			if err != nil {
+				return err
+			}
+		case instSlide:
+			gen.inst("call void @rt_slide(i32 %d)", i.offset)
+		case instBinOp:
+			gen.inst("call void @rt_binop(i32 %d, ptr %s)", i.op, gen.str(i.pos.String()))
+		case instEval:
+			gen.inst("call void @rt_eval()")
+		case instAlloc:
+			gen.inst("call void @rt_alloc_holes(i32 %d)", i.amount)
+		case instUnwind:
+			gen.inst("ret void")
+			gen.terminated = true
+		default:
+			return fmt.Errorf("Unknown instruction: %v", i)
+		}
+	}
+
+	return nil
+}
+
+// Branches are blocks selected with a switch on the tag, which continue in a
+// join block after the Jump unless they end in Unwind
+func (gen *llvmGenerator) jump(i instJump) error {
+	gen.jumps++
+	j := gen.jumps
+
+	cases := make([]string, 0)
+	for b := range i.branches {
+		for _, tag := range sortedTags(i.tagMappings, b) {
+			cases = append(cases, fmt.Sprintf("i32 %d, label %%branch.%d.%d", tag, j, b))
+		}
+	}
+	gen.inst("%%tag.%d = call i32 @rt_tag()", j)
+	gen.inst("switch i32 %%tag.%d, label %%nobranch.%d [ %s ]", j, j, strings.Join(cases, " "))
+	gen.terminated = true
+
+	for b, branch := range i.branches {
+		gen.label(fmt.Sprintf("branch.%d.%d", j, b))
+		err := gen.code(branch)
+		if err != nil {
+			return err
+		}
+		if !gen.terminated {
+			gen.inst("br label %%join.%d", j)
+			gen.terminated = true
+		}
+	}
+
+	gen.label(fmt.Sprintf("nobranch.%d", j))
+	gen.inst("call void @rt_no_branch(i32 %%tag.%d)", j)
+	gen.inst("unreachable")
+	gen.label(fmt.Sprintf("join.%d", j))
+
+	return nil
+}
+
+// Add a string constant and return a pointer to it
+func (gen *llvmGenerator) str(s string) string {
+	name := fmt.Sprintf("@str.%d", gen.strs)
+	gen.strs++
+
+	typ := fmt.Sprintf("[%d x i8]", len(s)+1)
+	fmt.Fprintf(gen.consts, "%s = private unnamed_addr constant %s c%s\n", name, typ, llvmString(s+"\x00"))
+
+	return name
+}
+
+func (gen *llvmGenerator) null() string {
+	return "null"
+}
+
+func (gen *llvmGenerator) fieldsArray(printer int, tag int, fields []int) string {
+	name := fmt.Sprintf("@fields.%d.%d", printer, tag)
+	gen.printf("%s = private constant %s\n", name, llvmInts(fields))
+
+	return name
+}
+
+func (gen *llvmGenerator) constructorEntry(c printerConstructor, fields string) string {
+	return fmt.Sprintf("%%printer_constructor { i32 %d, ptr %s, i32 %d, ptr %s }", c.tag, gen.str(c.name), len(c.fields), fields)
+}
+
+func (gen *llvmGenerator) constructorArray(printer int, entries []string) string {
+	name := fmt.Sprintf("@constructors.%d", printer)
+	gen.printf("%s = private constant %s\n", name, llvmArray("%printer_constructor", entries))
+
+	return name
+}
+
+func (gen *llvmGenerator) printerEntry(s printerSpec, constructors string) string {
+	return fmt.Sprintf("%%printer_info { i32 %d, i32 %d, ptr %s }", s.kind, len(s.constructors), constructors)
+}
+
+func (gen *llvmGenerator) printerArray(entries []string) {
+	gen.printf("@printers = private constant %s\n\n", llvmArray("%printer_info", entries))
+}
+
+func (gen *llvmGenerator) main(entry *entryPoint, result int, depth int) error {
+	e, args, err := gen.entry(entry)
+	if err != nil {
+		return err
+	}
+
+	argsPtr := "null"
+	if len(args) > 0 {
+		gen.printf("@args = private constant %s\n\n", llvmInts(args))
+		argsPtr = "@args"
+	}
+
+	gen.printf("define i32 @main() {\n")
+	gen.printf("entry:\n")
+	gen.printf("\tcall void @rt_init(ptr @globals, i32 %d)\n", len(gen.globals))
+	gen.printf("\t%%status = call i32 @rt_run(i32 %d, ptr %s, i32 %d, ptr @printers, i32 %d, i32 %d)\n", e, argsPtr, len(args), result, depth)
+	gen.printf("\tret i32 %%status\n")
+	gen.printf("}\n")
+
+	return nil
+}
+
+func llvmArray(elem string, values []string) string {
+	return fmt.Sprintf("[%d x %s] [\n\t%s\n]", len(values), elem, strings.Join(values, ",\n\t"))
+}
+
+func llvmInts(ns []int) string {
+	values := make([]string, 0)
+	for _, n := range ns {
+		values = append(values, fmt.Sprintf("i32 %d", n))
+	}
+
+	return fmt.Sprintf("[%d x i32] [%s]", len(ns), strings.Join(values, ", "))
+}
+
+// Quote s as an LLVM string constant
+func llvmString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < ' ' || c > '~' || c == '"' || c == '\\' {
+			fmt.Fprintf(&b, "\\%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+
+	return b.String()
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestBackendLLVM(t *testing.T) {
+	requireTools(t, "llc", "gcc")
+	testBackend(t, targetLLVM, func(output string) *exec.Cmd {
+		return exec.Command(output)
+	})
+}
+
+// The IR of every program of the corpus is valid for llvm-as
+func TestLLVMAssembles(t *testing.T) {
+	requireTools(t, "llvm-as")
+	flags, err := llvmFlags("llvm-as")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range corpus(t) {
+		p := compileFile(t, path, nil)
+		source, err := generateLLVM(p, programGlobals(p.defs), 8)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+
+		var stderr bytes.Buffer
+		cmd := exec.Command("llvm-as", append(flags, "-o", "/dev/null")...)
+		cmd.Stdin = strings.NewReader(source)
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			t.Errorf("%s: %v\n%s", path, err, stderr.String())
+		}
+	}
+}
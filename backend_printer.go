@@ -0,0 +1,178 @@
+package main
+
+import "github.com/pocket7878/fngo/rt"
+
+type printerKind = rt.PrinterKind
+
+const (
+	printerInt     = rt.PrinterInt
+	printerData    = rt.PrinterData
+	printerUnknown = rt.PrinterUnknown
+)
+
+// Limit for the number of printers, so nested data types such as
+// data T a = { Nest (T (List a)) } do not make the plan grow without bound
+const maxPrinters = 1024
+
+type (
+	// Backends print results the way resultPrinter does. Since they can not
+	// inspect types at run time, there is one printer for every type that
+	// can occur in the result.
+	printerPlan struct {
+		mgr      *typMgr
+		env      *typEnv
+		datas    map[string]*definitionData
+		printers []printerSpec
+		index    map[string]int
+		unknown  int
+	}
+
+	printerSpec struct {
+		kind printerKind
+		// Constructors of a data type, by tag
+		constructors []printerConstructor
+	}
+
+	printerConstructor struct {
+		tag    int
+		name   string
+		fields []int
+	}
+
+	// Syntax of the printer tables the C runtime reads, which the C and
+	// LLVM backends write. The array methods define a constant and return
+	// a reference to it.
+	printerTableWriter interface {
+		null() string
+		fieldsArray(printer int, tag int, fields []int) string
+		constructorEntry(c printerConstructor, fields string) string
+		constructorArray(printer int, entries []string) string
+		printerEntry(s printerSpec, constructors string) string
+		printerArray(entries []string)
+	}
+)
+
+func newPrinterPlan(p *program) *printerPlan {
+	datas := make(map[string]*definitionData)
+	for _, d := range p.defs {
+		if data, ok := d.(*definitionData); ok {
+			datas[data.name] = data
+		}
+	}
+
+	// Field types are found by unifying the types of constructors with the
+	// type of the result, which must not bind anything in the program
+	plan := &printerPlan{p.mgr.clone(), p.env, datas, make([]printerSpec, 0), make(map[string]int), 0}
+	plan.unknown = plan.add(printerSpec{printerUnknown, nil})
+
+	return plan
+}
+
+func (pp *printerPlan) add(s printerSpec) int {
+	pp.printers = append(pp.printers, s)
+	return len(pp.printers) - 1
+}
+
+// Index of the printer for values of type t
+func (pp *printerPlan) printerFor(t typ) int {
+	var v *typVar
+	resolved := pp.mgr.resolve(t, &v)
+	if base, ok := resolved.(*typBase); ok && base.name == "Int" {
+		return pp.lookup("Int", printerSpec{printerInt, nil})
+	}
+
+	dataTyp, ok := dataTypeOf(resolved)
+	if !ok {
+		return pp.unknown
+	}
+	data, ok := pp.datas[dataTyp.name]
+	if !ok {
+		return pp.unknown
+	}
+
+	key := t.typString(pp.mgr)
+	if i, ok := pp.index[key]; ok {
+		return i
+	}
+	if len(pp.printers) >= maxPrinters {
+		return pp.unknown
+	}
+
+	// Register before the fields, which may have the same type
+	i := pp.lookup(key, printerSpec{printerData, nil})
+	constructors := make([]printerConstructor, 0)
+	for _, c := range data.constructors {
+		fields := make([]int, 0)
+		for _, ft := range pp.fieldTypes(c, t) {
+			fields = append(fields, pp.printerFor(ft))
+		}
+		constructors = append(constructors, printerConstructor{c.tag, c.name, fields})
+	}
+	pp.printers[i].constructors = constructors
+
+	return i
+}
+
+func (pp *printerPlan) lookup(key string, s printerSpec) int {
+	if i, ok := pp.index[key]; ok {
+		return i
+	}
+
+	i := pp.add(s)
+	pp.index[key] = i
+
+	return i
+}
+
+// Field types of a constructor used at type t. Fields whose type can not be
+// found are printed without one.
+func (pp *printerPlan) fieldTypes(c constructor, t typ) []typ {
+	fieldTypes := make([]typ, 0)
+	s := pp.env.lookup(c.name)
+	if s == nil {
+		return pp.unknownFields(c)
+	}
+
+	constrTyp := s.instantiate(pp.mgr)
+	for {
+		arr, ok := constrTyp.(*typArr)
+		if !ok {
+			break
+		}
+		fieldTypes = append(fieldTypes, arr.left)
+		constrTyp = arr.right
+	}
+
+	if pp.mgr.unify(constrTyp, t) != nil {
+		return pp.unknownFields(c)
+	}
+
+	return fieldTypes
+}
+
+func (pp *printerPlan) unknownFields(c constructor) []typ {
+	return make([]typ, len(c.types))
+}
+
+// Every printer has an array of its constructors, and every constructor with
+// fields an array of the printers of its fields
+func (pp *printerPlan) writeTables(w printerTableWriter) {
+	infos := make([]string, 0)
+	for i, s := range pp.printers {
+		entries := make([]string, 0)
+		for _, c := range s.constructors {
+			fields := w.null()
+			if len(c.fields) > 0 {
+				fields = w.fieldsArray(i, c.tag, c.fields)
+			}
+			entries = append(entries, w.constructorEntry(c, fields))
+		}
+
+		constructors := w.null()
+		if len(entries) > 0 {
+			constructors = w.constructorArray(i, entries)
+		}
+		infos = append(infos, w.printerEntry(s, constructors))
+	}
+	w.printerArray(infos)
+}
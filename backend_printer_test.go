@@ -0,0 +1,38 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Planning the printers of a result leaves the types of the program alone
+func TestPrinterPlanKeepsTypes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fngo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "tree.fn")
+	err = ioutil.WriteFile(path, []byte(`
+data Tree a = { Leaf, Node (Tree a) a (Tree a) }
+data Pair a b = { Pair a b }
+defn main = { Node Leaf (Pair 1 (Node Leaf 2 Leaf)) Leaf }
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := compileFile(t, path, nil)
+	lastID, bound := p.mgr.lastID, len(p.mgr.types)
+	plan := newPrinterPlan(p)
+	plan.printerFor(p.entry.typ)
+
+	if len(plan.printers) < 4 {
+		t.Errorf("got %d printers, want one for each type in the result", len(plan.printers))
+	}
+	if p.mgr.lastID != lastID || len(p.mgr.types) != bound {
+		t.Errorf("planning printers changed the type manager of the program")
+	}
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Memory of the WebAssembly module: the static data from watDataBase, then
+// the globals, the stack, the output buffer and two spaces for the heap.
+// Larger spaces are added at the end of memory when the heap fills up.
+const (
+	watDataBase     = 64
+	watStackEntries = 1 << 20
+	watOutSize      = 1 << 16
+	watSpaceSize    = 1 << 24
+	watPageSize     = 1 << 16
+	// Every Eval nests wasm calls, and wasmtime gives wasm code 512KiB of
+	// stack by default, so evaluations are nested at most this deep
+	watEvalDepth = 1 << 11
+)
+
+// Translates the G-code of a program to a WebAssembly module in text form.
+// Every global becomes a function in the table the runtime calls through,
+// and the module runs under any WASI runtime.
+type watGenerator struct {
+	*backend
+	out  *strings.Builder
+	data []byte
+	strs map[string]int
+	// Number of jumps so far, for unique labels
+	jumps int
+}
+
+func generateWAT(p *program, globals []global, depth int) (string, error) {
+	gen := &watGenerator{newBackend(p, globals), &strings.Builder{}, make([]byte, 0), make(map[string]int), 0}
+	result := gen.plan.printerFor(p.entry.typ)
+
+	runtimeStrs := make([]int, 0)
+	for _, s := range watRuntimeStrings {
+		runtimeStrs = append(runtimeStrs, gen.str(s.text))
+	}
+
+	// Code is generated first, since it adds the positions to the data
+	code := &strings.Builder{}
+	out := gen.out
+	gen.out = code
+	for i, g := range globals {
+		gen.printf("  ;; %s\n", strings.Replace(g.name, "\n", " ", -1))
+		gen.printf("  (func $code_%d (type $code)\n", i)
+		gen.printf("    (local $tag i32)\n")
+		err := gen.code(g.code, "    ")
+		if err != nil {
+			return "", errors.Wrapf(err, "In %s", g.name)
+		}
+		gen.printf("  )\n\n")
+	}
+	err := gen.start(p.entry, result)
+	if err != nil {
+		return "", err
+	}
+	gen.out = out
+
+	names := make([]int, 0)
+	for _, g := range globals {
+		names = append(names, gen.str(g.name))
+	}
+	globalsInfo := gen.addr()
+	for i, g := range globals {
+		gen.word(g.arity)
+		gen.word(names[i])
+	}
+	printers := gen.printers()
+
+	dataEnd := align(gen.addr(), 8)
+	globalsBase := dataEnd
+	stackBase := align(globalsBase+4*len(globals), 8)
+	stackEnd := stackBase + 4*watStackEntries
+	outBase := stackEnd
+	fromSpace := outBase + watOutSize
+	toSpace := fromSpace + watSpaceSize
+	pages := (toSpace + watSpaceSize + watPageSize - 1) / watPageSize
+
+	gen.printf(";; Generated by fngo build -target wat\n")
+	gen.printf("(module\n")
+	gen.printf("  (import \"wasi_snapshot_preview1\" \"fd_write\" (func $fd_write (param i32 i32 i32 i32) (result i32)))\n")
+	gen.printf("  (import \"wasi_snapshot_preview1\" \"proc_exit\" (func $proc_exit (param i32)))\n\n")
+	gen.printf("  (type $code (func))\n")
+	gen.printf("  (memory (export \"memory\") %d)\n", pages)
+	gen.printf("  (table %d funcref)\n", len(globals))
+	gen.printf("  (elem (i32.const 0)")
+	for i := range globals {
+		gen.printf(" $code_%d", i)
+	}
+	gen.printf(")\n\n")
+
+	constants := []struct {
+		name  string
+		value int
+	}{
+		{"stack_base", stackBase},
+		{"stack_end", stackEnd},
+		{"globals", globalsBase},
+		{"nglobals", len(globals)},
+		{"globals_info", globalsInfo},
+		{"printers", printers},
+		{"max_depth", depth},
+		{"out_base", outBase},
+		{"out_size", watOutSize},
+		{"eval_depth_limit", watEvalDepth},
+	}
+	for _, c := range constants {
+		gen.printf("  (global $%s i32 (i32.const %d))\n", c.name, c.value)
+	}
+	for i, s := range watRuntimeStrings {
+		gen.printf("  (global $str.%s i32 (i32.const %d))\n", s.name, runtimeStrs[i])
+	}
+	variables := []struct {
+		name  string
+		value int
+	}{
+		{"sp", stackBase},
+		{"fp", stackBase},
+		{"hp", fromSpace},
+		{"limit", fromSpace + watSpaceSize},
+		{"from_space", fromSpace},
+		{"to_space", toSpace},
+		{"gc_free", 0},
+		{"space_size", watSpaceSize},
+		{"eval_depth", 0},
+		{"current", runtimeStrs[0]},
+		{"fd", 1},
+		{"out_len", 0},
+	}
+	for _, v := range variables {
+		gen.printf("  (global $%s (mut i32) (i32.const %d))\n", v.name, v.value)
+	}
+
+	gen.printf("%s\n", watRuntime)
+	gen.printf("%s", code.String())
+	gen.printf("  (data (i32.const %d) %s)\n", watDataBase, watString(gen.data))
+	gen.printf(")\n")
+
+	return gen.out.String(), nil
+}
+
+func (gen *watGenerator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(gen.out, format, args...)
+}
+
+// Address of the next byte of data
+func (gen *watGenerator) addr() int {
+	return watDataBase + len(gen.data)
+}
+
+func (gen *watGenerator) word(v int) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], uint32(int32(v)))
+	gen.data = append(gen.data, b[:]...)
+}
+
+// Add a string to the data and return its address. Data stays aligned to
+// words.
+func (gen *watGenerator) str(s string) int {
+	if a, ok := gen.strs[s]; ok {
+		return a
+	}
+
+	a := gen.addr()
+	gen.word(len(s))
+	gen.data = append(gen.data, s...)
+	for len(gen.data)%4 != 0 {
+		gen.data = append(gen.data, 0)
+	}
+	gen.strs[s] = a
+
+	return a
+}
+
+func align(n int, to int) int {
+	return (n + to - 1) / to * to
+}
+
+func (gen *watGenerator) code(code []inst, indent string) error {
+	for _, i := range code {
+		switch i := i.(type) {
+		case instPushInt:
+			gen.printf("%si64.const %d\n%scall $rt_pushint\n", indent, i.value, indent)
+		case instPushGlobal:
+			g, err := gen.global(i.name)
+			if err != nil {
+				return err
+			}
+			gen.printf("%si32.const %d\n%scall $rt_push_global\n", indent, g, indent)
+		case instPush:
+			gen.printf("%si32.const %d\n%scall $rt_push\n", indent, i.offset, indent)
+		case instPop:
+			gen.printf("%si32.const %d\n%scall $rt_pop\n", indent, i.count, indent)
+		case instMkApp:
+			gen.printf("%scall $rt_mkapp\n", indent)
+		case instUpdate:
+			gen.printf("%si32.const %d\n%scall $rt_update\n", indent, i.offset, indent)
+		case instPack:
+			gen.printf("%si32.const %d\n%si32.const %d\n%scall $rt_pack\n", indent, i.tag, indent, i.size, indent)
+		case instSplit:
+			gen.printf("%scall $rt_split\n", indent)
+		case instJump:
+			err := gen.jump(i, indent)
+			if err != nil {
+				return err
+			}
+		case instSlide:
+			gen.printf("%si32.const %d\n%scall $rt_slide\n", indent, i.offset, indent)
+		case instBinOp:
+			gen.printf("%si32.const %d\n%si32.const %d\n%scall $rt_binop\n", indent, i.op, indent, gen.str(i.pos.String()), indent)
+		case instEval:
+			gen.printf("%scall $rt_eval\n", indent)
+		case instAlloc:
+			gen.printf("%si32.const %d\n%scall $rt_alloc_holes\n", indent, i.amount, indent)
+		case instUnwind:
+			gen.printf("%sreturn\n", indent)
+		default:
+			return fmt.Errorf("Unknown instruction: %v", i)
+		}
+	}
+
+	return nil
+}
+
+// A Jump is a br_table in the innermost of one block per branch. The code
+// of a branch follows the end of its block and leaves the outermost block
+// when it is done.
+func (gen *watGenerator) jump(i instJump, indent string) error {
+	gen.jumps++
+	j := gen.jumps
+
+	tags := 0
+	for tag := range i.tagMappings {
+		if tag+1 > tags {
+			tags = tag + 1
+		}
+	}
+	targets := make([]string, 0)
+	for tag := 0; tag < tags; tag++ {
+		b, ok := i.tagMappings[tag]
+		if ok {
+			targets = append(targets, fmt.Sprintf("$branch.%d.%d", j, b))
+		} else {
+			targets = append(targets, fmt.Sprintf("$none.%d", j))
+		}
+	}
+	targets = append(targets, fmt.Sprintf("$none.%d", j))
+
+	inner := indent + "  "
+	gen.printf("%sblock $join.%d\n", indent, j)
+	for b := len(i.branches) - 1; b >= 0; b-- {
+		gen.printf("%sblock $branch.%d.%d\n", inner, j, b)
+		inner += "  "
+	}
+	gen.printf("%sblock $none.%d\n", inner, j)
+	gen.printf("%s  call $rt_tag\n", inner)
+	gen.printf("%s  local.tee $tag\n", inner)
+	gen.printf("%s  br_table %s\n", inner, strings.Join(targets, " "))
+	gen.printf("%send\n", inner)
+	gen.printf("%slocal.get $tag\n", inner)
+	gen.printf("%scall $rt_no_branch\n", inner)
+	gen.printf("%sunreachable\n", inner)
+
+	for _, branch := range i.branches {
+		inner = inner[:len(inner)-2]
+		gen.printf("%send\n", inner)
+		err := gen.code(branch, inner)
+		if err != nil {
+			return err
+		}
+		gen.printf("%sbr $join.%d\n", inner, j)
+	}
+	gen.printf("%send\n", indent)
+
+	return nil
+}
+
+// Printer tables in the layout $find_constructor reads, returning the
+// address of the printers
+func (gen *watGenerator) printers() int {
+	constructors := make([]int, 0)
+	for _, s := range gen.plan.printers {
+		fields := make([]int, 0)
+		names := make([]int, 0)
+		for _, c := range s.constructors {
+			names = append(names, gen.str(c.name))
+			if len(c.fields) == 0 {
+				fields = append(fields, 0)
+				continue
+			}
+			fields = append(fields, gen.addr())
+			for _, f := range c.fields {
+				gen.word(f)
+			}
+		}
+
+		constructors = append(constructors, gen.addr())
+		for i, c := range s.constructors {
+			gen.word(c.tag)
+			gen.word(names[i])
+			gen.word(len(c.fields))
+			gen.word(fields[i])
+		}
+	}
+
+	printers := gen.addr()
+	for i, s := range gen.plan.printers {
+		gen.word(s.kind)
+		gen.word(len(s.constructors))
+		gen.word(constructors[i])
+	}
+
+	return printers
+}
+
+// The exported _start function, which the WASI host calls. It pushes the
+// entry point, applies it with $rt_apply and prints through the printer
+// tables in the data segment.
+func (gen *watGenerator) start(entry *entryPoint, result int) error {
+	e, args, err := gen.entry(entry)
+	if err != nil {
+		return err
+	}
+
+	gen.printf("  (func $_start (export \"_start\")\n")
+	gen.printf("    call $rt_init\n")
+	gen.printf("    i32.const %d\n    call $rt_push_global\n", e)
+	for _, a := range args {
+		gen.printf("    i32.const %d\n    call $rt_apply\n", a)
+	}
+	gen.printf("    i32.const %d\n    call $rt_print_result\n", result)
+	gen.printf("  )\n\n")
+
+	return nil
+}
+
+// Quote data as a WebAssembly string
+func watString(data []byte) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, c := range data {
+		if c < ' ' || c > '~' || c == '"' || c == '\\' {
+			fmt.Fprintf(&b, "\\%02x", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+
+	return b.String()
+}
@@ -0,0 +1,1483 @@
+package main
+
+// Strings the WebAssembly runtime refers to as $str.<name>
+var watRuntimeStrings = []struct {
+	name string
+	text string
+}{
+	{"empty", ""},
+	{"runtime_error", "Runtime error"},
+	{"in", " in "},
+	{"colon", ": "},
+	{"stack_overflow", "Stack overflow"},
+	{"eval_depth", "Stack overflow: evaluation nested deeper than "},
+	{"out_of_memory", "Out of memory"},
+	{"hole", "Value depends on itself"},
+	{"expected_data", "Expected data node"},
+	{"expected_app", "Expected application node"},
+	{"not_a_number", "Not a number"},
+	{"division_by_zero", "Division by zero"},
+	{"no_branch", "No branch for tag "},
+	{"function", "<function>"},
+	{"tag", "<tag "},
+	{"cutoff", "..."},
+}
+
+// Runtime of the WebAssembly backend. Nodes live in linear memory and are
+// moved by a copying collector, so, as in the C runtime, the instructions
+// allocate before they read their operands from the stack.
+//
+// Node layout, in 16 bytes unless noted:
+//
+//	App     tag 0, left at 4, right at 8
+//	Num     tag 1, i64 value at 8
+//	Global  tag 2, arity at 4, table index at 8, name at 12
+//	Ind     tag 3, target at 4, or 0 for a hole
+//	Data    tag 4, constructor tag at 4, size at 8, fields from 16
+//	Forward tag 5, new address at 4, only while collecting
+//
+// Strings are an i32 length followed by the bytes. Addresses 0 to 63 are
+// scratch space for fd_write and number formatting.
+const watRuntime = `
+  (func $fail_begin
+    call $flush
+    i32.const 2
+    global.set $fd
+    global.get $str.runtime_error
+    call $write_str
+    global.get $current
+    i32.load
+    if
+      global.get $str.in
+      call $write_str
+      global.get $current
+      call $write_str
+    end
+    global.get $str.colon
+    call $write_str
+  )
+
+  (func $fail_end
+    i32.const 10
+    call $write_byte
+    call $flush
+    i32.const 1
+    call $proc_exit
+    unreachable
+  )
+
+  (func $fail (param $msg i32)
+    call $fail_begin
+    local.get $msg
+    call $write_str
+    call $fail_end
+  )
+
+  (func $fail_at (param $pos i32) (param $msg i32)
+    call $fail_begin
+    local.get $pos
+    call $write_str
+    global.get $str.colon
+    call $write_str
+    local.get $msg
+    call $write_str
+    call $fail_end
+  )
+
+  ;; Output
+
+  (func $flush
+    (local $p i32)
+    (local $len i32)
+    global.get $out_base
+    local.set $p
+    global.get $out_len
+    local.set $len
+    block $done
+      loop $more
+        local.get $len
+        i32.eqz
+        br_if $done
+        i32.const 0
+        local.get $p
+        i32.store
+        i32.const 4
+        local.get $len
+        i32.store
+        global.get $fd
+        i32.const 0
+        i32.const 1
+        i32.const 8
+        call $fd_write
+        br_if $done
+        local.get $p
+        i32.const 8
+        i32.load
+        i32.add
+        local.set $p
+        local.get $len
+        i32.const 8
+        i32.load
+        i32.sub
+        local.set $len
+        br $more
+      end
+    end
+    i32.const 0
+    global.set $out_len
+  )
+
+  (func $write_byte (param $b i32)
+    global.get $out_base
+    global.get $out_len
+    i32.add
+    local.get $b
+    i32.store8
+    global.get $out_len
+    i32.const 1
+    i32.add
+    global.set $out_len
+    global.get $out_len
+    global.get $out_size
+    i32.ge_u
+    if
+      call $flush
+    end
+  )
+
+  (func $write_str (param $s i32)
+    (local $i i32)
+    block $done
+      loop $each
+        local.get $i
+        local.get $s
+        i32.load
+        i32.ge_u
+        br_if $done
+        local.get $s
+        local.get $i
+        i32.add
+        i32.load8_u offset=4
+        call $write_byte
+        local.get $i
+        i32.const 1
+        i32.add
+        local.set $i
+        br $each
+      end
+    end
+  )
+
+  ;; Digits are formatted backwards into the scratch space below 40
+  (func $write_num (param $v i64)
+    (local $u i64)
+    (local $p i32)
+    local.get $v
+    local.set $u
+    local.get $v
+    i64.const 0
+    i64.lt_s
+    if
+      i32.const 45
+      call $write_byte
+      i64.const 0
+      local.get $v
+      i64.sub
+      local.set $u
+    end
+    i32.const 40
+    local.set $p
+    loop $digit
+      local.get $p
+      i32.const 1
+      i32.sub
+      local.set $p
+      local.get $p
+      local.get $u
+      i64.const 10
+      i64.rem_u
+      i32.wrap_i64
+      i32.const 48
+      i32.add
+      i32.store8
+      local.get $u
+      i64.const 10
+      i64.div_u
+      local.set $u
+      local.get $u
+      i64.eqz
+      i32.eqz
+      br_if $digit
+    end
+    block $done
+      loop $each
+        local.get $p
+        i32.const 40
+        i32.ge_u
+        br_if $done
+        local.get $p
+        i32.load8_u
+        call $write_byte
+        local.get $p
+        i32.const 1
+        i32.add
+        local.set $p
+        br $each
+      end
+    end
+  )
+
+  ;; Stack
+
+  (func $push (param $n i32)
+    global.get $sp
+    global.get $stack_end
+    i32.ge_u
+    if
+      global.get $str.stack_overflow
+      call $fail
+    end
+    global.get $sp
+    local.get $n
+    i32.store
+    global.get $sp
+    i32.const 4
+    i32.add
+    global.set $sp
+  )
+
+  (func $peek (param $i i32) (result i32)
+    global.get $sp
+    local.get $i
+    i32.const 2
+    i32.shl
+    i32.sub
+    i32.const 4
+    i32.sub
+    i32.load
+  )
+
+  (func $drop (param $n i32)
+    global.get $sp
+    local.get $n
+    i32.const 2
+    i32.shl
+    i32.sub
+    global.set $sp
+  )
+
+  ;; Heap
+
+  (func $node_size (param $n i32) (result i32)
+    local.get $n
+    i32.load
+    i32.const 4
+    i32.eq
+    if
+      local.get $n
+      i32.load offset=8
+      i32.const 2
+      i32.shl
+      i32.const 23
+      i32.add
+      i32.const -8
+      i32.and
+      return
+    end
+    i32.const 16
+  )
+
+  ;; A collection which leaves less than half of the space free is followed
+  ;; by a move to larger spaces
+  (func $alloc (param $size i32) (result i32)
+    global.get $hp
+    local.get $size
+    i32.add
+    global.get $limit
+    i32.gt_u
+    if
+      call $collect
+      global.get $hp
+      global.get $from_space
+      i32.sub
+      local.get $size
+      i32.add
+      global.get $space_size
+      i32.const 1
+      i32.shr_u
+      i32.gt_u
+      if
+        local.get $size
+        call $grow
+      end
+    end
+    global.get $hp
+    global.get $hp
+    local.get $size
+    i32.add
+    global.set $hp
+  )
+
+  (func $copy (param $n i32) (result i32)
+    (local $new i32)
+    (local $size i32)
+    (local $i i32)
+    local.get $n
+    i32.eqz
+    if
+      i32.const 0
+      return
+    end
+    local.get $n
+    i32.load
+    i32.const 5
+    i32.eq
+    if
+      local.get $n
+      i32.load offset=4
+      return
+    end
+    local.get $n
+    call $node_size
+    local.set $size
+    global.get $gc_free
+    local.set $new
+    block $done
+      loop $words
+        local.get $i
+        local.get $size
+        i32.ge_u
+        br_if $done
+        local.get $new
+        local.get $i
+        i32.add
+        local.get $n
+        local.get $i
+        i32.add
+        i64.load
+        i64.store
+        local.get $i
+        i32.const 8
+        i32.add
+        local.set $i
+        br $words
+      end
+    end
+    global.get $gc_free
+    local.get $size
+    i32.add
+    global.set $gc_free
+    local.get $n
+    i32.const 5
+    i32.store
+    local.get $n
+    local.get $new
+    i32.store offset=4
+    local.get $new
+  )
+
+  (func $copy_slot (param $a i32)
+    local.get $a
+    local.get $a
+    i32.load
+    call $copy
+    i32.store
+  )
+
+  (func $copy_slots (param $p i32) (param $end i32)
+    block $done
+      loop $each
+        local.get $p
+        local.get $end
+        i32.ge_u
+        br_if $done
+        local.get $p
+        call $copy_slot
+        local.get $p
+        i32.const 4
+        i32.add
+        local.set $p
+        br $each
+      end
+    end
+  )
+
+  ;; Copy everything reachable from the stack and the globals to the other
+  ;; space, scanning the copies breadth first
+  (func $collect
+    (local $scan i32)
+    (local $tag i32)
+    (local $space i32)
+    global.get $to_space
+    global.set $gc_free
+    global.get $stack_base
+    global.get $sp
+    call $copy_slots
+    global.get $globals
+    global.get $globals
+    global.get $nglobals
+    i32.const 2
+    i32.shl
+    i32.add
+    call $copy_slots
+    global.get $to_space
+    local.set $scan
+    block $done
+      loop $each
+        local.get $scan
+        global.get $gc_free
+        i32.ge_u
+        br_if $done
+        local.get $scan
+        i32.load
+        local.set $tag
+        local.get $tag
+        i32.const 0
+        i32.eq
+        if
+          local.get $scan
+          i32.const 4
+          i32.add
+          call $copy_slot
+          local.get $scan
+          i32.const 8
+          i32.add
+          call $copy_slot
+        end
+        local.get $tag
+        i32.const 3
+        i32.eq
+        if
+          local.get $scan
+          i32.const 4
+          i32.add
+          call $copy_slot
+        end
+        local.get $tag
+        i32.const 4
+        i32.eq
+        if
+          local.get $scan
+          i32.const 16
+          i32.add
+          local.get $scan
+          i32.const 16
+          i32.add
+          local.get $scan
+          i32.load offset=8
+          i32.const 2
+          i32.shl
+          i32.add
+          call $copy_slots
+        end
+        local.get $scan
+        local.get $scan
+        call $node_size
+        i32.add
+        local.set $scan
+        br $each
+      end
+    end
+    global.get $to_space
+    local.set $space
+    global.get $from_space
+    global.set $to_space
+    local.get $space
+    global.set $from_space
+    global.get $gc_free
+    global.set $hp
+    global.get $from_space
+    global.get $space_size
+    i32.add
+    global.set $limit
+  )
+
+  ;; Add two spaces twice as large as needed at the end of memory and collect
+  ;; into one of them. The old spaces are not used again. When memory can not
+  ;; grow, the program fails with out of memory.
+  (func $grow (param $need i32)
+    (local $size i32)
+    (local $base i32)
+    global.get $space_size
+    local.set $size
+    block $large_enough
+      loop $double
+        global.get $hp
+        global.get $from_space
+        i32.sub
+        local.get $need
+        i32.add
+        local.get $size
+        i32.const 1
+        i32.shr_u
+        i32.le_u
+        br_if $large_enough
+        local.get $size
+        i32.const 1073741824
+        i32.ge_u
+        if
+          global.get $str.out_of_memory
+          call $fail
+        end
+        local.get $size
+        i32.const 1
+        i32.shl
+        local.set $size
+        br $double
+      end
+    end
+    memory.size
+    i32.const 16
+    i32.shl
+    local.set $base
+    local.get $size
+    i32.const 15
+    i32.shr_u
+    memory.grow
+    i32.const -1
+    i32.eq
+    if
+      global.get $str.out_of_memory
+      call $fail
+    end
+    local.get $base
+    global.set $to_space
+    local.get $size
+    global.set $space_size
+    call $collect
+    local.get $base
+    local.get $size
+    i32.add
+    global.set $to_space
+  )
+
+  ;; The globals table holds the arity and name of every global
+  (func $rt_init
+    (local $i i32)
+    (local $n i32)
+    (local $info i32)
+    block $done
+      loop $each
+        local.get $i
+        global.get $nglobals
+        i32.ge_u
+        br_if $done
+        i32.const 16
+        call $alloc
+        local.set $n
+        global.get $globals_info
+        local.get $i
+        i32.const 3
+        i32.shl
+        i32.add
+        local.set $info
+        local.get $n
+        i32.const 2
+        i32.store
+        local.get $n
+        local.get $info
+        i32.load
+        i32.store offset=4
+        local.get $n
+        local.get $i
+        i32.store offset=8
+        local.get $n
+        local.get $info
+        i32.load offset=4
+        i32.store offset=12
+        global.get $globals
+        local.get $i
+        i32.const 2
+        i32.shl
+        i32.add
+        local.get $n
+        i32.store
+        local.get $i
+        i32.const 1
+        i32.add
+        local.set $i
+        br $each
+      end
+    end
+  )
+
+  ;; Instructions. Offsets count from the top of the stack, as in compEnv.
+
+  (func $rt_push (param $offset i32)
+    local.get $offset
+    call $peek
+    call $push
+  )
+
+  (func $rt_pop (param $count i32)
+    local.get $count
+    call $drop
+  )
+
+  (func $rt_push_global (param $i i32)
+    global.get $globals
+    local.get $i
+    i32.const 2
+    i32.shl
+    i32.add
+    i32.load
+    call $push
+  )
+
+  (func $rt_pushint (param $v i64)
+    (local $n i32)
+    i32.const 16
+    call $alloc
+    local.set $n
+    local.get $n
+    i32.const 1
+    i32.store
+    local.get $n
+    local.get $v
+    i64.store offset=8
+    local.get $n
+    call $push
+  )
+
+  (func $rt_mkapp
+    (local $n i32)
+    i32.const 16
+    call $alloc
+    local.set $n
+    local.get $n
+    i32.const 0
+    i32.store
+    local.get $n
+    i32.const 0
+    call $peek
+    i32.store offset=4
+    local.get $n
+    i32.const 1
+    call $peek
+    i32.store offset=8
+    i32.const 2
+    call $drop
+    local.get $n
+    call $push
+  )
+
+  ;; Apply the node on top of the stack to a global
+  (func $rt_apply (param $i i32)
+    (local $n i32)
+    i32.const 16
+    call $alloc
+    local.set $n
+    local.get $n
+    i32.const 0
+    i32.store
+    local.get $n
+    i32.const 0
+    call $peek
+    i32.store offset=4
+    local.get $n
+    global.get $globals
+    local.get $i
+    i32.const 2
+    i32.shl
+    i32.add
+    i32.load
+    i32.store offset=8
+    i32.const 1
+    call $drop
+    local.get $n
+    call $push
+  )
+
+  ;; An indirection chain from $a back to $target, as letrec x = x makes,
+  ;; would loop when unwound, so $target is left a hole instead
+  (func $rt_update (param $offset i32)
+    (local $a i32)
+    (local $target i32)
+    (local $n i32)
+    i32.const 0
+    call $peek
+    local.set $a
+    local.get $offset
+    i32.const 1
+    i32.add
+    call $peek
+    local.set $target
+    i32.const 1
+    call $drop
+    local.get $a
+    local.set $n
+    block $checked
+      loop $follow
+        local.get $n
+        i32.eqz
+        br_if $checked
+        local.get $n
+        local.get $target
+        i32.eq
+        if
+          i32.const 0
+          local.set $a
+          br $checked
+        end
+        local.get $n
+        i32.load
+        i32.const 3
+        i32.ne
+        br_if $checked
+        local.get $n
+        i32.load offset=4
+        local.set $n
+        br $follow
+      end
+    end
+    local.get $target
+    i32.const 3
+    i32.store
+    local.get $target
+    local.get $a
+    i32.store offset=4
+  )
+
+  (func $rt_pack (param $tag i32) (param $size i32)
+    (local $n i32)
+    (local $i i32)
+    local.get $size
+    i32.const 2
+    i32.shl
+    i32.const 23
+    i32.add
+    i32.const -8
+    i32.and
+    call $alloc
+    local.set $n
+    local.get $n
+    i32.const 4
+    i32.store
+    local.get $n
+    local.get $tag
+    i32.store offset=4
+    local.get $n
+    local.get $size
+    i32.store offset=8
+    block $done
+      loop $each
+        local.get $i
+        local.get $size
+        i32.ge_u
+        br_if $done
+        local.get $n
+        local.get $i
+        i32.const 2
+        i32.shl
+        i32.add
+        local.get $i
+        call $peek
+        i32.store offset=16
+        local.get $i
+        i32.const 1
+        i32.add
+        local.set $i
+        br $each
+      end
+    end
+    local.get $size
+    call $drop
+    local.get $n
+    call $push
+  )
+
+  (func $expect_data (param $n i32) (result i32)
+    local.get $n
+    i32.load
+    i32.const 4
+    i32.ne
+    if
+      global.get $str.expected_data
+      call $fail
+    end
+    local.get $n
+  )
+
+  (func $rt_split
+    (local $n i32)
+    (local $i i32)
+    i32.const 0
+    call $peek
+    call $expect_data
+    local.set $n
+    i32.const 1
+    call $drop
+    local.get $n
+    i32.load offset=8
+    local.set $i
+    block $done
+      loop $each
+        local.get $i
+        i32.eqz
+        br_if $done
+        local.get $i
+        i32.const 1
+        i32.sub
+        local.set $i
+        local.get $n
+        local.get $i
+        i32.const 2
+        i32.shl
+        i32.add
+        i32.load offset=16
+        call $push
+        br $each
+      end
+    end
+  )
+
+  (func $rt_tag (result i32)
+    i32.const 0
+    call $peek
+    call $expect_data
+    i32.load offset=4
+  )
+
+  (func $rt_no_branch (param $tag i32)
+    call $fail_begin
+    global.get $str.no_branch
+    call $write_str
+    local.get $tag
+    i64.extend_i32_s
+    call $write_num
+    call $fail_end
+  )
+
+  (func $rt_slide (param $n i32)
+    (local $a i32)
+    i32.const 0
+    call $peek
+    local.set $a
+    local.get $n
+    i32.const 1
+    i32.add
+    call $drop
+    local.get $a
+    call $push
+  )
+
+  (func $rt_alloc_holes (param $n i32)
+    (local $h i32)
+    block $done
+      loop $each
+        local.get $n
+        i32.eqz
+        br_if $done
+        i32.const 16
+        call $alloc
+        local.set $h
+        local.get $h
+        i32.const 3
+        i32.store
+        local.get $h
+        i32.const 0
+        i32.store offset=4
+        local.get $h
+        call $push
+        local.get $n
+        i32.const 1
+        i32.sub
+        local.set $n
+        br $each
+      end
+    end
+  )
+
+  (func $num_value (param $n i32) (param $pos i32) (result i64)
+    local.get $n
+    i32.load
+    i32.const 1
+    i32.ne
+    if
+      local.get $pos
+      global.get $str.not_a_number
+      call $fail_at
+    end
+    local.get $n
+    i64.load offset=8
+  )
+
+  ;; Integers wrap around like Go's int. Comparisons give a Bool, a data
+  ;; node of the same size as a number, so the node is allocated first.
+  (func $rt_binop (param $op i32) (param $pos i32)
+    (local $n i32)
+    (local $l i64)
+    (local $r i64)
+    (local $result i64)
+    (local $cmp i32)
+    i32.const 16
+    call $alloc
+    local.set $n
+    i32.const 0
+    call $peek
+    local.get $pos
+    call $num_value
+    local.set $l
+    i32.const 1
+    call $peek
+    local.get $pos
+    call $num_value
+    local.set $r
+    i32.const -1
+    local.set $cmp
+    block $done
+      local.get $op
+      i32.const 0
+      i32.eq
+      if
+        local.get $l
+        local.get $r
+        i64.add
+        local.set $result
+        br $done
+      end
+      local.get $op
+      i32.const 1
+      i32.eq
+      if
+        local.get $l
+        local.get $r
+        i64.sub
+        local.set $result
+        br $done
+      end
+      local.get $op
+      i32.const 2
+      i32.eq
+      if
+        local.get $l
+        local.get $r
+        i64.mul
+        local.set $result
+        br $done
+      end
+      local.get $op
+      i32.const 3
+      i32.eq
+      if
+        local.get $r
+        i64.eqz
+        if
+          local.get $pos
+          global.get $str.division_by_zero
+          call $fail_at
+        end
+        local.get $r
+        i64.const -1
+        i64.eq
+        if
+          i64.const 0
+          local.get $l
+          i64.sub
+          local.set $result
+          br $done
+        end
+        local.get $l
+        local.get $r
+        i64.div_s
+        local.set $result
+        br $done
+      end
+      local.get $op
+      i32.const 4
+      i32.eq
+      if
+        local.get $l
+        local.get $r
+        i64.eq
+        local.set $cmp
+        br $done
+      end
+      local.get $op
+      i32.const 5
+      i32.eq
+      if
+        local.get $l
+        local.get $r
+        i64.ne
+        local.set $cmp
+        br $done
+      end
+      local.get $op
+      i32.const 6
+      i32.eq
+      if
+        local.get $l
+        local.get $r
+        i64.lt_s
+        local.set $cmp
+        br $done
+      end
+      local.get $op
+      i32.const 7
+      i32.eq
+      if
+        local.get $l
+        local.get $r
+        i64.le_s
+        local.set $cmp
+        br $done
+      end
+      local.get $op
+      i32.const 8
+      i32.eq
+      if
+        local.get $l
+        local.get $r
+        i64.gt_s
+        local.set $cmp
+        br $done
+      end
+      local.get $l
+      local.get $r
+      i64.ge_s
+      local.set $cmp
+    end
+    local.get $cmp
+    i32.const 0
+    i32.ge_s
+    if
+      local.get $n
+      i32.const 4
+      i32.store
+      local.get $n
+      local.get $cmp
+      i32.store offset=4
+      local.get $n
+      i32.const 0
+      i32.store offset=8
+    else
+      local.get $n
+      i32.const 1
+      i32.store
+      local.get $n
+      local.get $result
+      i64.store offset=8
+    end
+    i32.const 2
+    call $drop
+    local.get $n
+    call $push
+  )
+
+  ;; The code of a global ends in Unwind, which returns to this loop
+  (func $unwind
+    (local $n i32)
+    (local $tag i32)
+    (local $arity i32)
+    (local $i i32)
+    (local $app i32)
+    loop $step
+      i32.const 0
+      call $peek
+      local.set $n
+      local.get $n
+      i32.load
+      local.set $tag
+      local.get $tag
+      i32.const 0
+      i32.eq
+      if
+        local.get $n
+        i32.load offset=4
+        call $push
+        br $step
+      end
+      local.get $tag
+      i32.const 3
+      i32.eq
+      if
+        local.get $n
+        i32.load offset=4
+        i32.eqz
+        if
+          global.get $str.hole
+          call $fail
+        end
+        global.get $sp
+        i32.const 4
+        i32.sub
+        local.get $n
+        i32.load offset=4
+        i32.store
+        br $step
+      end
+      local.get $tag
+      i32.const 2
+      i32.eq
+      if
+        local.get $n
+        i32.load offset=4
+        local.set $arity
+        global.get $sp
+        global.get $fp
+        i32.sub
+        i32.const 2
+        i32.shr_s
+        local.get $arity
+        i32.le_s
+        if
+          ;; A partial application is in WHNF, its value is the root
+          global.get $fp
+          i32.const 4
+          i32.add
+          global.set $sp
+          return
+        end
+        i32.const 1
+        local.set $i
+        block $done
+          loop $each
+            local.get $i
+            local.get $arity
+            i32.gt_s
+            br_if $done
+            local.get $i
+            call $peek
+            local.set $app
+            local.get $app
+            i32.load
+            i32.const 0
+            i32.ne
+            if
+              global.get $str.expected_app
+              call $fail
+            end
+            global.get $sp
+            local.get $i
+            i32.const 2
+            i32.shl
+            i32.sub
+            local.get $app
+            i32.load offset=8
+            i32.store
+            local.get $i
+            i32.const 1
+            i32.add
+            local.set $i
+            br $each
+          end
+        end
+        local.get $n
+        i32.load offset=12
+        global.set $current
+        local.get $n
+        i32.load offset=8
+        call_indirect (type $code)
+        br $step
+      end
+    end
+  )
+
+  ;; The dump is the wasm call stack: a frame starts at the node being
+  ;; evaluated
+  (func $rt_eval
+    (local $saved_fp i32)
+    (local $saved_current i32)
+    (local $a i32)
+    global.get $eval_depth
+    i32.const 1
+    i32.add
+    global.set $eval_depth
+    global.get $eval_depth
+    global.get $eval_depth_limit
+    i32.gt_u
+    if
+      call $fail_begin
+      global.get $str.eval_depth
+      call $write_str
+      global.get $eval_depth_limit
+      i64.extend_i32_u
+      call $write_num
+      call $fail_end
+    end
+    global.get $fp
+    local.set $saved_fp
+    global.get $current
+    local.set $saved_current
+    global.get $sp
+    i32.const 4
+    i32.sub
+    global.set $fp
+    call $unwind
+    i32.const 0
+    call $peek
+    local.set $a
+    global.get $fp
+    global.set $sp
+    local.get $a
+    call $push
+    local.get $saved_fp
+    global.set $fp
+    local.get $saved_current
+    global.set $current
+    global.get $eval_depth
+    i32.const 1
+    i32.sub
+    global.set $eval_depth
+  )
+
+  ;; Printing. A printer is its kind, the number of constructors and their
+  ;; address; a constructor is its tag, name, size and the printers of its
+  ;; fields, or 0 if their types are unknown.
+
+  (func $find_constructor (param $p i32) (param $tag i32) (param $size i32) (result i32)
+    (local $info i32)
+    (local $i i32)
+    (local $c i32)
+    local.get $p
+    i32.const 0
+    i32.lt_s
+    if
+      i32.const 0
+      return
+    end
+    global.get $printers
+    local.get $p
+    i32.const 12
+    i32.mul
+    i32.add
+    local.set $info
+    local.get $info
+    i32.load
+    i32.const 1
+    i32.ne
+    if
+      i32.const 0
+      return
+    end
+    block $done
+      loop $each
+        local.get $i
+        local.get $info
+        i32.load offset=4
+        i32.ge_u
+        br_if $done
+        local.get $info
+        i32.load offset=8
+        local.get $i
+        i32.const 4
+        i32.shl
+        i32.add
+        local.set $c
+        local.get $c
+        i32.load
+        local.get $tag
+        i32.eq
+        local.get $c
+        i32.load offset=8
+        local.get $size
+        i32.eq
+        i32.and
+        if
+          local.get $c
+          return
+        end
+        local.get $i
+        i32.const 1
+        i32.add
+        local.set $i
+        br $each
+      end
+    end
+    i32.const 0
+  )
+
+  ;; Print n with printer p, or without a type if p is negative. The node is
+  ;; kept on the stack and read back after every field, since printing a
+  ;; field may move it.
+  (func $print (param $p i32) (param $n i32) (param $depth i32)
+    (local $c i32)
+    (local $fields i32)
+    (local $size i32)
+    (local $i i32)
+    local.get $n
+    call $push
+    call $rt_eval
+    i32.const 0
+    call $peek
+    local.set $n
+    block $done
+      local.get $n
+      i32.load
+      i32.const 1
+      i32.eq
+      if
+        local.get $n
+        i64.load offset=8
+        call $write_num
+        br $done
+      end
+      local.get $n
+      i32.load
+      i32.const 4
+      i32.ne
+      if
+        global.get $str.function
+        call $write_str
+        br $done
+      end
+      local.get $n
+      i32.load offset=8
+      local.set $size
+      local.get $depth
+      global.get $max_depth
+      i32.ge_s
+      local.get $size
+      i32.const 0
+      i32.gt_u
+      i32.and
+      if
+        global.get $str.cutoff
+        call $write_str
+        br $done
+      end
+      local.get $p
+      local.get $n
+      i32.load offset=4
+      local.get $size
+      call $find_constructor
+      local.set $c
+      local.get $c
+      if
+        local.get $c
+        i32.load offset=4
+        call $write_str
+        local.get $c
+        i32.load offset=12
+        local.set $fields
+      else
+        global.get $str.tag
+        call $write_str
+        local.get $n
+        i32.load offset=4
+        i64.extend_i32_s
+        call $write_num
+        i32.const 62
+        call $write_byte
+      end
+      block $fields_done
+        loop $each
+          local.get $i
+          local.get $size
+          i32.ge_u
+          br_if $fields_done
+          i32.const 32
+          call $write_byte
+          local.get $fields
+          local.get $i
+          i32.const 2
+          i32.shl
+          i32.add
+          i32.load
+          i32.const -1
+          local.get $fields
+          select
+          i32.const 0
+          call $peek
+          local.get $i
+          i32.const 2
+          i32.shl
+          i32.add
+          i32.load offset=16
+          local.get $depth
+          i32.const 1
+          i32.add
+          call $print_field
+          local.get $i
+          i32.const 1
+          i32.add
+          local.set $i
+          br $each
+        end
+      end
+    end
+    i32.const 1
+    call $drop
+  )
+
+  ;; Output is not buffered, so whether a field needs parentheses is worked
+  ;; out from its node before it is printed: a negative number, a constructor
+  ;; with fields or one shown as <tag n> does, and a cut off one does not.
+  (func $print_field (param $p i32) (param $n i32) (param $depth i32)
+    (local $paren i32)
+    (local $size i32)
+    local.get $n
+    call $push
+    call $rt_eval
+    i32.const 0
+    call $peek
+    local.set $n
+    local.get $n
+    i32.load
+    i32.const 1
+    i32.eq
+    if
+      local.get $n
+      i64.load offset=8
+      i64.const 0
+      i64.lt_s
+      local.set $paren
+    end
+    local.get $n
+    i32.load
+    i32.const 4
+    i32.eq
+    if
+      local.get $n
+      i32.load offset=8
+      local.set $size
+      local.get $depth
+      global.get $max_depth
+      i32.lt_s
+      local.get $size
+      i32.eqz
+      i32.or
+      if
+        local.get $size
+        i32.const 0
+        i32.gt_u
+        local.get $p
+        local.get $n
+        i32.load offset=4
+        local.get $size
+        call $find_constructor
+        i32.eqz
+        i32.or
+        local.set $paren
+      end
+    end
+    local.get $paren
+    if
+      i32.const 40
+      call $write_byte
+    end
+    local.get $p
+    local.get $n
+    local.get $depth
+    call $print
+    local.get $paren
+    if
+      i32.const 41
+      call $write_byte
+    end
+    i32.const 1
+    call $drop
+  )
+
+  (func $rt_print_result (param $p i32)
+    local.get $p
+    i32.const 0
+    call $peek
+    i32.const 0
+    call $print
+    i32.const 10
+    call $write_byte
+    call $flush
+  )
+`
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os/exec"
+	"testing"
+)
+
+func runWasm(output string) *exec.Cmd {
+	return exec.Command("wasmtime", output)
+}
+
+func TestBackendWAT(t *testing.T) {
+	requireTools(t, "wat2wasm", "wasmtime")
+	testBackend(t, targetWAT, runWasm)
+}
+
+// Nested evaluation fails with a runtime error before the wasm stack of the
+// host runs out
+func TestBackendWATEvalDepth(t *testing.T) {
+	requireTools(t, "wat2wasm", "wasmtime")
+	got := buildSource(t, targetWAT, deepSum, []string{"100000"}, runWasm)
+	want := "error: Runtime error in range: Stack overflow: evaluation nested deeper than 2048"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// The list is kept alive while it is walked, which takes more than the
+// initial spaces of the heap
+func TestBackendWATGrowsMemory(t *testing.T) {
+	requireTools(t, "wat2wasm", "wasmtime")
+	src := `
+data List a = { Nil, Cons a (List a) }
+defn range n = { if n == 0 then Nil else Cons n (range (n - 1)) }
+defn sum acc l = { case l of { Nil -> { acc } Cons x xs -> { sum (acc + x) xs } } }
+defn length acc l = { case l of { Nil -> { acc } Cons x xs -> { length (acc + 1) xs } } }
+defn main n = { let l = range n in length 0 l + sum 0 l }
+`
+	got := buildSource(t, targetWAT, src, []string{"1000000"}, runWasm)
+	if want := "500001500000"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+type benchResult struct {
+	Interpreter string        `json:"interpreter"`
+	Runs        int           `json:"runs"`
+	PerRun      time.Duration `json:"perRunNs"`
+}
+
+var interpreterNames = []string{"inst", "dense"}
+
+// Run the entry point with every interpreter, each time on a fresh VM, and
+// check that they agree on the result
+func benchCommand(opts *commandOptions, args []string) error {
+	if opts.runs < 1 {
+		return fmt.Errorf("Number of runs must be positive: %d", opts.runs)
+	}
+
+	p, err := checkFile(args[0])
+	if err != nil {
+		return err
+	}
+	err = p.setEntry(opts.entry, args[1:])
+	if err != nil {
+		return err
+	}
+	err = p.compile()
+	if err != nil {
+		return err
+	}
+	globals := programGlobals(p.defs)
+
+	results := make([]benchResult, 0)
+	expected := ""
+	for _, name := range interpreterNames {
+		ro := opts.runOptions()
+		ro.interp, _ = parseInterpreter(name)
+
+		start := time.Now()
+		for i := 0; i < opts.runs; i++ {
+			result, _, err := runProgram(p, globals, ro)
+			if err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+			if expected == "" {
+				expected = result
+			} else if result != expected {
+				return fmt.Errorf("%s: result %s differs from %s", name, result, expected)
+			}
+		}
+		perRun := time.Since(start) / time.Duration(opts.runs)
+		results = append(results, benchResult{name, opts.runs, perRun})
+	}
+
+	speedup := float64(results[0].PerRun) / float64(results[len(results)-1].PerRun)
+	if opts.format == formatJSON {
+		return writeJSON(os.Stdout, struct {
+			Result  string        `json:"result"`
+			Results []benchResult `json:"results"`
+			Speedup float64       `json:"speedup"`
+		}{expected, results, speedup})
+	}
+
+	for _, r := range results {
+		fmt.Printf("%-6s %d runs %12v/run\n", r.Interpreter, r.Runs, r.PerRun)
+	}
+	fmt.Printf("speedup %.2fx\n", speedup)
+
+	return nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Run the entry point of every corpus program on a fresh VM in every
+// iteration, as the bench command does. Some programs end in a runtime
+// error, which is their result like any other.
+func benchmarkInterpreter(b *testing.B, interp interpreter) {
+	for _, path := range corpus(b) {
+		p := compileFile(b, path, nil)
+		globals := programGlobals(p.defs)
+		opts := runOptions{traceResult, overflowWrap, defaultPrintDepth, interp}
+
+		name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				runProgram(p, globals, opts)
+			}
+		})
+	}
+}
+
+func BenchmarkInst(b *testing.B) {
+	benchmarkInterpreter(b, interpInst)
+}
+
+func BenchmarkDense(b *testing.B) {
+	benchmarkInterpreter(b, interpDense)
+}
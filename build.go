@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"go/token"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+type buildTarget = int
+
+const (
+	// C source including the runtime, built with the C compiler
+	targetC buildTarget = iota
+	// LLVM IR, built with llc and linked with the runtime by the C compiler
+	targetLLVM
+	// WebAssembly text for WASI runtimes, assembled with wat2wasm
+	targetWAT
+)
+
+func parseBuildTarget(s string) (buildTarget, error) {
+	switch s {
+	case "c":
+		return targetC, nil
+	case "llvm":
+		return targetLLVM, nil
+	case "wat":
+		return targetWAT, nil
+	default:
+		return targetC, fmt.Errorf("Unknown target: %s", s)
+	}
+}
+
+// Translate the program for the target and build an executable from it, or
+// write the translation when opts.emit is set
+func buildCommand(opts *commandOptions, args []string) error {
+	if opts.runtime != "" && opts.target != targetLLVM {
+		return fmt.Errorf("Only the llvm target has a separate runtime")
+	}
+
+	p, err := checkFile(args[0])
+	if err != nil {
+		return err
+	}
+	err = p.setEntry(opts.entry, args[1:])
+	if err != nil {
+		return err
+	}
+	err = p.compile()
+	if err != nil {
+		return err
+	}
+
+	globals := programGlobals(p.defs)
+	var source string
+	switch opts.target {
+	case targetLLVM:
+		source, err = generateLLVM(p, globals, opts.depth)
+	case targetWAT:
+		source, err = generateWAT(p, globals, opts.depth)
+	default:
+		source, err = generateC(p, globals, opts.depth)
+	}
+	if err != nil {
+		return errors.Wrap(err, "Compile Error")
+	}
+
+	if opts.runtime != "" {
+		err = ioutil.WriteFile(opts.runtime, []byte(llvmRuntime), 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.emit {
+		var w io.Writer = os.Stdout
+		if opts.output != "" {
+			file, err := os.Create(opts.output)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			w = file
+		}
+		_, err = io.WriteString(w, source)
+		return err
+	}
+
+	output := opts.output
+	if output == "" {
+		base := filepath.Base(args[0])
+		output = strings.TrimSuffix(base, filepath.Ext(base))
+		if opts.target == targetWAT {
+			output += ".wasm"
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "fngo")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	switch opts.target {
+	case targetLLVM:
+		return buildLLVM(opts, dir, source, output)
+	case targetWAT:
+		return buildWAT(opts, dir, source, output)
+	default:
+		return buildC(opts, dir, source, output)
+	}
+}
+
+// Translate the program to a Go package running on the rt package of fngo
+func genGoCommand(opts *commandOptions, args []string) error {
+	if !token.IsIdentifier(opts.pkg) {
+		return fmt.Errorf("Invalid package name: %s", opts.pkg)
+	}
+
+	p, err := checkFile(args[0])
+	if err != nil {
+		return err
+	}
+	err = p.setEntry(opts.entry, args[1:])
+	if err != nil {
+		return err
+	}
+	err = p.compile()
+	if err != nil {
+		return err
+	}
+
+	source, err := generateGo(p, programGlobals(p.defs), opts.depth, opts.pkg)
+	if err != nil {
+		return errors.Wrap(err, "Compile Error")
+	}
+
+	var w io.Writer = os.Stdout
+	if opts.output != "" {
+		file, err := os.Create(opts.output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	_, err = io.WriteString(w, source)
+
+	return err
+}
+
+func buildC(opts *commandOptions, dir string, source string, output string) error {
+	path := filepath.Join(dir, "main.c")
+	err := ioutil.WriteFile(path, []byte(source), 0644)
+	if err != nil {
+		return err
+	}
+
+	return runTool(opts.cc, "-O2", "-o", output, path)
+}
+
+func buildLLVM(opts *commandOptions, dir string, source string, output string) error {
+	ir := filepath.Join(dir, "main.ll")
+	asm := filepath.Join(dir, "main.s")
+	runtime := filepath.Join(dir, "runtime.c")
+	err := ioutil.WriteFile(ir, []byte(source), 0644)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(runtime, []byte(llvmRuntime), 0644)
+	if err != nil {
+		return err
+	}
+
+	flags, err := llvmFlags(opts.llc)
+	if err != nil {
+		return err
+	}
+	err = runTool(opts.llc, append(flags, "-O2", "-relocation-model=pic", "-o", asm, ir)...)
+	if err != nil {
+		return err
+	}
+
+	return runTool(opts.cc, "-O2", "-o", output, asm, runtime)
+}
+
+var llvmVersionPattern = regexp.MustCompile(`LLVM version (\d+)`)
+
+// Flags an LLVM tool needs to read the IR of the llvm target
+func llvmFlags(tool string) ([]string, error) {
+	out, err := exec.Command(tool, "--version").Output()
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s failed", tool)
+	}
+
+	m := llvmVersionPattern.FindSubmatch(out)
+	if m == nil {
+		return nil, nil
+	}
+	version, err := strconv.Atoi(string(m[1]))
+	if err != nil || version >= 15 {
+		return nil, nil
+	}
+
+	return []string{"-opaque-pointers"}, nil
+}
+
+func buildWAT(opts *commandOptions, dir string, source string, output string) error {
+	path := filepath.Join(dir, "main.wat")
+	err := ioutil.WriteFile(path, []byte(source), 0644)
+	if err != nil {
+		return err
+	}
+
+	return runTool(opts.wat2wasm, "-o", output, path)
+}
+
+// Run an external tool, passing its output through to stderr
+func runTool(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+	if err != nil {
+		return errors.Wrapf(err, "%s failed", name)
+	}
+
+	return nil
+}
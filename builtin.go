@@ -0,0 +1,57 @@
+package main
+
+import (
+	"text/scanner"
+
+	"github.com/pocket7878/fngo/rt"
+)
+
+// Tags of the constructors of the built-in Bool type
+const (
+	boolFalseTag = rt.FalseTag
+	boolTrueTag  = rt.TrueTag
+)
+
+// Data types every program can use without defining them
+func builtinDefinitions() []definition {
+	pos := scanner.Position{Filename: "<builtin>"}
+
+	return []definition{
+		&definitionData{
+			"Bool",
+			make([]string, 0),
+			[]constructor{
+				{"False", make([]parsedType, 0), boolFalseTag, nil, pos},
+				{"True", make([]parsedType, 0), boolTrueTag, nil, pos},
+			},
+			nil,
+			pos,
+		},
+	}
+}
+
+func bindBuiltinTypes(e *typEnv) {
+	e.bindType("Int", &typBase{"Int"})
+}
+
+// Must be called after the built-in data types are typechecked
+func bindOperatorTypes(e *typEnv) {
+	intTyp := e.lookupType("Int")
+	boolTyp := e.lookupType("Bool")
+
+	for op := binOpPlus; op <= binOpGe; op++ {
+		resultTyp := intTyp
+		if isComparison(op) {
+			resultTyp = boolTyp
+		}
+
+		o, _ := opName(op)
+		e.bind(o, &typArr{
+			intTyp,
+			&typArr{
+				intTyp,
+				resultTyp,
+			},
+		})
+	}
+}
@@ -0,0 +1,822 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/scanner"
+)
+
+const (
+	bytecodeMagic   = "FNGO"
+	bytecodeVersion = 2
+
+	// Upper bound for lengths read from a file, so that a corrupt file does
+	// not make the reader allocate without limit
+	bytecodeMaxLength = 1 << 24
+
+	// Upper bound for constructor tags, which index the branch tables of Jump
+	bytecodeMaxTag = 1 << 16
+)
+
+// Compiled program as it is written to a file: the globals with their code
+// and types, the data types needed to print results and the entry point. The
+// binary format and the JSON format store this same structure.
+type (
+	bytecode struct {
+		Version   int              `json:"version"`
+		Entry     string           `json:"entry"`
+		EntryArgs []string         `json:"entryArgs"`
+		EntryType typeTerm         `json:"entryType"`
+		Data      []bytecodeData   `json:"data"`
+		Globals   []bytecodeGlobal `json:"globals"`
+	}
+
+	bytecodeData struct {
+		Name         string                `json:"name"`
+		Params       []string              `json:"params"`
+		Constructors []bytecodeConstructor `json:"constructors"`
+	}
+
+	bytecodeConstructor struct {
+		Name   string     `json:"name"`
+		Tag    int        `json:"tag"`
+		Fields []typeTerm `json:"fields"`
+	}
+
+	// Lifted lambdas have no type, as they are not in the environment
+	bytecodeGlobal struct {
+		Name  string         `json:"name"`
+		Arity int            `json:"arity"`
+		Type  *typeTerm      `json:"type,omitempty"`
+		Code  []bytecodeInst `json:"code"`
+	}
+
+	// Operands of every instruction are stored in Args, except the name of
+	// PushGlobal, the position of BinOp and the branches of Jump
+	bytecodeInst struct {
+		Op       string           `json:"op"`
+		Args     []int            `json:"args,omitempty"`
+		Name     string           `json:"name,omitempty"`
+		Pos      *bytecodePos     `json:"pos,omitempty"`
+		Branches [][]bytecodeInst `json:"branches,omitempty"`
+		Tags     map[int]int      `json:"tags,omitempty"`
+	}
+
+	bytecodePos struct {
+		Filename string `json:"filename"`
+		Line     int    `json:"line"`
+		Column   int    `json:"column"`
+	}
+
+	// A type variable, a named type applied to arguments or an arrow
+	typeTerm struct {
+		Kind string     `json:"kind"`
+		Name string     `json:"name,omitempty"`
+		Args []typeTerm `json:"args,omitempty"`
+	}
+)
+
+const (
+	typeTermVar = "var"
+	typeTermCon = "con"
+	typeTermArr = "arr"
+)
+
+// Opcodes of the binary format are indices into this table, so new
+// instructions must only be appended
+var bytecodeOps = []string{
+	"PushInt",
+	"PushGlobal",
+	"Push",
+	"Pop",
+	"MkApp",
+	"Update",
+	"Pack",
+	"Split",
+	"Jump",
+	"Slide",
+	"BinOp",
+	"Eval",
+	"Alloc",
+	"Unwind",
+}
+
+var typeTermKinds = []string{typeTermVar, typeTermCon, typeTermArr}
+
+// The entry point of the program must be set
+func newBytecode(p *program) *bytecode {
+	b := &bytecode{
+		bytecodeVersion,
+		p.entry.name,
+		p.entry.args,
+		encodeType(p.mgr, p.entry.typ),
+		make([]bytecodeData, 0),
+		make([]bytecodeGlobal, 0),
+	}
+
+	for _, d := range p.defs {
+		data, ok := d.(*definitionData)
+		if !ok {
+			continue
+		}
+
+		constructors := make([]bytecodeConstructor, 0)
+		for _, c := range data.constructors {
+			fields := make([]typeTerm, 0)
+			for _, t := range c.types {
+				fields = append(fields, encodeParsedType(t))
+			}
+			constructors = append(constructors, bytecodeConstructor{c.name, c.tag, fields})
+		}
+		b.Data = append(b.Data, bytecodeData{data.name, data.params, constructors})
+	}
+
+	for _, g := range programGlobals(p.defs) {
+		var t *typeTerm
+		if s := p.env.lookup(g.name); s != nil {
+			term := encodeType(p.mgr, s.monotype)
+			t = &term
+		}
+		b.Globals = append(b.Globals, bytecodeGlobal{g.name, g.arity, t, encodeInsts(g.code)})
+	}
+
+	return b
+}
+
+// Rebuild the data types and the type of the entry point, which the result
+// printer needs, and the types of the globals, against which a new entry
+// point and its arguments are typechecked
+func (b *bytecode) program() (*program, error) {
+	defs := make([]definition, 0)
+	for _, d := range b.Data {
+		constructors := make([]constructor, 0)
+		for _, c := range d.Constructors {
+			types := make([]parsedType, 0)
+			for _, f := range c.Fields {
+				t, err := f.toParsedType()
+				if err != nil {
+					return nil, err
+				}
+				types = append(types, t)
+			}
+			constructors = append(constructors, constructor{c.Name, types, c.Tag, nil, scanner.Position{}})
+		}
+		defs = append(defs, &definitionData{d.Name, d.Params, constructors, nil, scanner.Position{}})
+	}
+
+	mgr, e, err := typecheckProgram(defs)
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range b.Globals {
+		if g.Type == nil || e.lookup(g.Name) != nil {
+			continue
+		}
+		t, err := g.Type.toType(mgr, e, make(map[string]typ))
+		if err != nil {
+			return nil, err
+		}
+		e.bindScheme(g.Name, mgr.generalize(t, e))
+	}
+	t, err := b.EntryType.toType(mgr, e, make(map[string]typ))
+	if err != nil {
+		return nil, err
+	}
+
+	return &program{defs, mgr, e, &entryPoint{b.Entry, b.EntryArgs, t}}, nil
+}
+
+func (b *bytecode) globals() ([]global, error) {
+	globals := make([]global, 0)
+	for _, g := range b.Globals {
+		if g.Arity < 0 || g.Arity > bytecodeMaxLength {
+			return nil, fmt.Errorf("Corrupt bytecode: %s: arity out of range: %d", g.Name, g.Arity)
+		}
+		code, err := decodeInsts(g.Code)
+		if err != nil {
+			return nil, fmt.Errorf("Corrupt bytecode: %s: %v", g.Name, err)
+		}
+		globals = append(globals, global{g.Name, g.Arity, code})
+	}
+
+	return globals, nil
+}
+
+// Read a compiled program together with the globals to store on the heap
+// of the VM which runs it
+func loadBytecode(path string) (*program, []global, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	b, err := readBytecode(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	p, err := b.program()
+	if err != nil {
+		return nil, nil, err
+	}
+	globals, err := b.globals()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return p, globals, nil
+}
+
+func encodeInsts(code []inst) []bytecodeInst {
+	result := make([]bytecodeInst, 0)
+	for _, i := range code {
+		result = append(result, encodeInst(i))
+	}
+
+	return result
+}
+
+func encodeInst(i inst) bytecodeInst {
+	switch i := i.(type) {
+	case instPushInt:
+		return bytecodeInst{Op: "PushInt", Args: []int{i.value}}
+	case instPushGlobal:
+		return bytecodeInst{Op: "PushGlobal", Name: i.name}
+	case instPush:
+		return bytecodeInst{Op: "Push", Args: []int{i.offset}}
+	case instPop:
+		return bytecodeInst{Op: "Pop", Args: []int{i.count}}
+	case instMkApp:
+		return bytecodeInst{Op: "MkApp"}
+	case instUpdate:
+		return bytecodeInst{Op: "Update", Args: []int{i.offset}}
+	case instPack:
+		return bytecodeInst{Op: "Pack", Args: []int{i.tag, i.size}}
+	case instSplit:
+		return bytecodeInst{Op: "Split"}
+	case instJump:
+		branches := make([][]bytecodeInst, 0)
+		for _, b := range i.branches {
+			branches = append(branches, encodeInsts(b))
+		}
+		return bytecodeInst{Op: "Jump", Branches: branches, Tags: i.tagMappings}
+	case instSlide:
+		return bytecodeInst{Op: "Slide", Args: []int{i.offset}}
+	case instBinOp:
+		return bytecodeInst{Op: "BinOp", Args: []int{i.op}, Pos: &bytecodePos{i.pos.Filename, i.pos.Line, i.pos.Column}}
+	case instEval:
+		return bytecodeInst{Op: "Eval"}
+	case instAlloc:
+		return bytecodeInst{Op: "Alloc", Args: []int{i.amount}}
+	case instUnwind:
+		return bytecodeInst{Op: "Unwind"}
+	default:
+		panic(fmt.Sprintf("Unknown instruction: %v", i))
+	}
+}
+
+func decodeInsts(code []bytecodeInst) ([]inst, error) {
+	result := make([]inst, 0)
+	for _, i := range code {
+		decoded, err := decodeInst(i)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, decoded)
+	}
+
+	return result, nil
+}
+
+// Number of operands in Args, for the instructions which have any
+var bytecodeArgCounts = map[string]int{
+	"PushInt": 1,
+	"Push":    1,
+	"Pop":     1,
+	"Update":  1,
+	"Pack":    2,
+	"Slide":   1,
+	"BinOp":   1,
+	"Alloc":   1,
+}
+
+// Offsets, counts and sizes are checked against the same bound as lengths
+// in the binary format, so a corrupt file cannot make the VM index outside
+// the stack or allocate without limit
+func checkOperand(op string, what string, v int, max int) error {
+	if v < 0 || v >= max {
+		return fmt.Errorf("%s %s out of range: %d", op, what, v)
+	}
+
+	return nil
+}
+
+func decodeInst(i bytecodeInst) (inst, error) {
+	if len(i.Args) != bytecodeArgCounts[i.Op] {
+		return nil, fmt.Errorf("%s expects %d operands, got %d", i.Op, bytecodeArgCounts[i.Op], len(i.Args))
+	}
+
+	var err error
+	switch i.Op {
+	case "Push", "Update", "Slide":
+		err = checkOperand(i.Op, "offset", i.Args[0], bytecodeMaxLength)
+	case "Pop", "Alloc":
+		err = checkOperand(i.Op, "count", i.Args[0], bytecodeMaxLength)
+	case "Pack":
+		err = checkOperand(i.Op, "tag", i.Args[0], bytecodeMaxTag)
+		if err == nil {
+			err = checkOperand(i.Op, "size", i.Args[1], bytecodeMaxLength)
+		}
+	case "Jump":
+		for tag := range i.Tags {
+			if err == nil {
+				err = checkOperand(i.Op, "tag", tag, bytecodeMaxTag)
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch i.Op {
+	case "PushInt":
+		return instPushInt{i.Args[0]}, nil
+	case "PushGlobal":
+		return instPushGlobal{i.Name}, nil
+	case "Push":
+		return instPush{i.Args[0]}, nil
+	case "Pop":
+		return instPop{i.Args[0]}, nil
+	case "MkApp":
+		return instMkApp{}, nil
+	case "Update":
+		return instUpdate{i.Args[0]}, nil
+	case "Pack":
+		return instPack{i.Args[0], i.Args[1]}, nil
+	case "Split":
+		return instSplit{}, nil
+	case "Jump":
+		branches := make([][]inst, 0)
+		for _, b := range i.Branches {
+			branch, err := decodeInsts(b)
+			if err != nil {
+				return nil, err
+			}
+			branches = append(branches, branch)
+		}
+		for tag, b := range i.Tags {
+			if b < 0 || b >= len(branches) {
+				return nil, fmt.Errorf("Jump maps tag %d to missing branch %d", tag, b)
+			}
+		}
+		return instJump{branches, i.Tags}, nil
+	case "Slide":
+		return instSlide{i.Args[0]}, nil
+	case "BinOp":
+		if _, err := opName(i.Args[0]); err != nil {
+			return nil, err
+		}
+		pos := scanner.Position{}
+		if i.Pos != nil {
+			pos = scanner.Position{Filename: i.Pos.Filename, Line: i.Pos.Line, Column: i.Pos.Column}
+		}
+		return instBinOp{i.Args[0], pos}, nil
+	case "Eval":
+		return instEval{}, nil
+	case "Alloc":
+		return instAlloc{i.Args[0]}, nil
+	case "Unwind":
+		return instUnwind{}, nil
+	default:
+		return nil, fmt.Errorf("Unknown instruction: %s", i.Op)
+	}
+}
+
+func encodeType(m *typMgr, t typ) typeTerm {
+	var v *typVar
+	switch t := m.resolve(t, &v).(type) {
+	case *typVar:
+		return typeTerm{typeTermVar, t.name, nil}
+	case *typBase:
+		return typeTerm{typeTermCon, t.name, nil}
+	case *typData:
+		return typeTerm{typeTermCon, t.name, nil}
+	case *typApp:
+		constructor := encodeType(m, t.constructor)
+		for _, arg := range t.args {
+			constructor.Args = append(constructor.Args, encodeType(m, arg))
+		}
+		return constructor
+	case *typArr:
+		return typeTerm{typeTermArr, "", []typeTerm{encodeType(m, t.left), encodeType(m, t.right)}}
+	default:
+		panic(fmt.Sprintf("Unknown type: %v", t))
+	}
+}
+
+func encodeParsedType(t parsedType) typeTerm {
+	switch t := t.(type) {
+	case *parsedTypeVar:
+		return typeTerm{typeTermVar, t.name, nil}
+	case *parsedTypeApp:
+		args := make([]typeTerm, 0)
+		for _, arg := range t.args {
+			args = append(args, encodeParsedType(arg))
+		}
+		return typeTerm{typeTermCon, t.name, args}
+	default:
+		panic(fmt.Sprintf("Unknown type: %v", t))
+	}
+}
+
+// Variables with the same name become the same fresh type variable
+func (t typeTerm) toType(m *typMgr, e *typEnv, vars map[string]typ) (typ, error) {
+	switch t.Kind {
+	case typeTermVar:
+		v, ok := vars[t.Name]
+		if !ok {
+			v = m.newTyp()
+			vars[t.Name] = v
+		}
+		return v, nil
+	case typeTermCon:
+		base := e.lookupType(t.Name)
+		if base == nil {
+			return nil, fmt.Errorf("Undefined type: %s", t.Name)
+		}
+		if len(t.Args) == 0 {
+			return base, nil
+		}
+		args := make([]typ, 0)
+		for _, arg := range t.Args {
+			a, err := arg.toType(m, e, vars)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, a)
+		}
+		return &typApp{base, args}, nil
+	case typeTermArr:
+		if len(t.Args) != 2 {
+			return nil, fmt.Errorf("Arrow type needs 2 arguments, got %d", len(t.Args))
+		}
+		left, err := t.Args[0].toType(m, e, vars)
+		if err != nil {
+			return nil, err
+		}
+		right, err := t.Args[1].toType(m, e, vars)
+		if err != nil {
+			return nil, err
+		}
+		return &typArr{left, right}, nil
+	default:
+		return nil, fmt.Errorf("Unknown type kind: %s", t.Kind)
+	}
+}
+
+// Constructor fields only contain variables and named types
+func (t typeTerm) toParsedType() (parsedType, error) {
+	switch t.Kind {
+	case typeTermVar:
+		return &parsedTypeVar{t.Name}, nil
+	case typeTermCon:
+		args := make([]parsedType, 0)
+		for _, arg := range t.Args {
+			a, err := arg.toParsedType()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, a)
+		}
+		return &parsedTypeApp{t.Name, args}, nil
+	default:
+		return nil, fmt.Errorf("Unexpected type kind in constructor field: %s", t.Kind)
+	}
+}
+
+func writeBytecode(w io.Writer, format outputFormat, b *bytecode) error {
+	if format == formatJSON {
+		return writeJSON(w, b)
+	}
+
+	bw := &bytecodeWriter{bufio.NewWriter(w), nil}
+	bw.bytecode(b)
+	if bw.err != nil {
+		return bw.err
+	}
+
+	return bw.w.Flush()
+}
+
+// Files starting with the magic are binary, anything else is read as JSON
+func readBytecode(r io.Reader) (*bytecode, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(len(bytecodeMagic))
+
+	b := &bytecode{}
+	if bytes.Equal(magic, []byte(bytecodeMagic)) {
+		reader := &bytecodeReader{br, nil}
+		b = reader.bytecode()
+		if reader.err != nil {
+			return nil, fmt.Errorf("Corrupt bytecode: %v", reader.err)
+		}
+	} else {
+		err := json.NewDecoder(br).Decode(b)
+		if err != nil {
+			return nil, fmt.Errorf("Corrupt bytecode: %v", err)
+		}
+	}
+
+	if b.Version != bytecodeVersion {
+		return nil, fmt.Errorf("Unsupported bytecode version %d, expected %d", b.Version, bytecodeVersion)
+	}
+
+	return b, nil
+}
+
+// Writes the binary format. The first error is kept and every later write is
+// skipped.
+type bytecodeWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (w *bytecodeWriter) uint(v int) {
+	if w.err != nil {
+		return
+	}
+	buf := make([]byte, binary.MaxVarintLen64)
+	_, w.err = w.w.Write(buf[:binary.PutUvarint(buf, uint64(v))])
+}
+
+func (w *bytecodeWriter) int(v int) {
+	if w.err != nil {
+		return
+	}
+	buf := make([]byte, binary.MaxVarintLen64)
+	_, w.err = w.w.Write(buf[:binary.PutVarint(buf, int64(v))])
+}
+
+func (w *bytecodeWriter) string(s string) {
+	w.uint(len(s))
+	if w.err != nil {
+		return
+	}
+	_, w.err = w.w.WriteString(s)
+}
+
+func (w *bytecodeWriter) bytecode(b *bytecode) {
+	if w.err == nil {
+		_, w.err = w.w.WriteString(bytecodeMagic)
+	}
+	w.uint(b.Version)
+	w.string(b.Entry)
+	w.uint(len(b.EntryArgs))
+	for _, a := range b.EntryArgs {
+		w.string(a)
+	}
+	w.typeTerm(b.EntryType)
+
+	w.uint(len(b.Data))
+	for _, d := range b.Data {
+		w.string(d.Name)
+		w.uint(len(d.Params))
+		for _, p := range d.Params {
+			w.string(p)
+		}
+		w.uint(len(d.Constructors))
+		for _, c := range d.Constructors {
+			w.string(c.Name)
+			w.int(c.Tag)
+			w.uint(len(c.Fields))
+			for _, f := range c.Fields {
+				w.typeTerm(f)
+			}
+		}
+	}
+
+	w.uint(len(b.Globals))
+	for _, g := range b.Globals {
+		w.string(g.Name)
+		w.uint(g.Arity)
+		if g.Type == nil {
+			w.uint(0)
+		} else {
+			w.uint(1)
+			w.typeTerm(*g.Type)
+		}
+		w.insts(g.Code)
+	}
+}
+
+func (w *bytecodeWriter) typeTerm(t typeTerm) {
+	kind := 0
+	for i, k := range typeTermKinds {
+		if k == t.Kind {
+			kind = i
+		}
+	}
+	w.uint(kind)
+	w.string(t.Name)
+	w.uint(len(t.Args))
+	for _, arg := range t.Args {
+		w.typeTerm(arg)
+	}
+}
+
+func (w *bytecodeWriter) insts(code []bytecodeInst) {
+	w.uint(len(code))
+	for _, i := range code {
+		op := 0
+		for n, name := range bytecodeOps {
+			if name == i.Op {
+				op = n
+			}
+		}
+		w.uint(op)
+		for _, arg := range i.Args {
+			w.int(arg)
+		}
+
+		switch i.Op {
+		case "PushGlobal":
+			w.string(i.Name)
+		case "BinOp":
+			pos := bytecodePos{}
+			if i.Pos != nil {
+				pos = *i.Pos
+			}
+			w.string(pos.Filename)
+			w.uint(pos.Line)
+			w.uint(pos.Column)
+		case "Jump":
+			w.uint(len(i.Branches))
+			for _, b := range i.Branches {
+				w.insts(b)
+			}
+
+			tags := make([]int, 0)
+			for tag := range i.Tags {
+				tags = append(tags, tag)
+			}
+			sort.Ints(tags)
+			w.uint(len(tags))
+			for _, tag := range tags {
+				w.int(tag)
+				w.uint(i.Tags[tag])
+			}
+		}
+	}
+}
+
+// Reads the binary format. After the first error every read returns a zero
+// value.
+type bytecodeReader struct {
+	r   *bufio.Reader
+	err error
+}
+
+func (r *bytecodeReader) uint() int {
+	if r.err != nil {
+		return 0
+	}
+	v, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		r.err = err
+		return 0
+	}
+	if v > bytecodeMaxLength {
+		r.err = fmt.Errorf("value out of range: %d", v)
+		return 0
+	}
+
+	return int(v)
+}
+
+func (r *bytecodeReader) int() int {
+	if r.err != nil {
+		return 0
+	}
+	v, err := binary.ReadVarint(r.r)
+	r.err = err
+
+	return int(v)
+}
+
+func (r *bytecodeReader) string() string {
+	n := r.uint()
+	if r.err != nil {
+		return ""
+	}
+	buf := make([]byte, n)
+	_, r.err = io.ReadFull(r.r, buf)
+
+	return string(buf)
+}
+
+func (r *bytecodeReader) bytecode() *bytecode {
+	magic := make([]byte, len(bytecodeMagic))
+	_, r.err = io.ReadFull(r.r, magic)
+
+	b := &bytecode{}
+	b.Version = r.uint()
+	if r.err == nil && b.Version != bytecodeVersion {
+		// Later versions may lay out the rest differently
+		return b
+	}
+	b.Entry = r.string()
+	b.EntryArgs = make([]string, 0)
+	for n := r.uint(); n > 0 && r.err == nil; n-- {
+		b.EntryArgs = append(b.EntryArgs, r.string())
+	}
+	b.EntryType = r.typeTerm()
+
+	b.Data = make([]bytecodeData, 0)
+	for n := r.uint(); n > 0 && r.err == nil; n-- {
+		d := bytecodeData{r.string(), make([]string, 0), make([]bytecodeConstructor, 0)}
+		for m := r.uint(); m > 0 && r.err == nil; m-- {
+			d.Params = append(d.Params, r.string())
+		}
+		for m := r.uint(); m > 0 && r.err == nil; m-- {
+			c := bytecodeConstructor{r.string(), r.int(), make([]typeTerm, 0)}
+			for k := r.uint(); k > 0 && r.err == nil; k-- {
+				c.Fields = append(c.Fields, r.typeTerm())
+			}
+			d.Constructors = append(d.Constructors, c)
+		}
+		b.Data = append(b.Data, d)
+	}
+
+	b.Globals = make([]bytecodeGlobal, 0)
+	for n := r.uint(); n > 0 && r.err == nil; n-- {
+		g := bytecodeGlobal{Name: r.string(), Arity: r.uint()}
+		if r.uint() != 0 {
+			t := r.typeTerm()
+			g.Type = &t
+		}
+		g.Code = r.insts()
+		b.Globals = append(b.Globals, g)
+	}
+
+	return b
+}
+
+func (r *bytecodeReader) typeTerm() typeTerm {
+	kind := r.uint()
+	if r.err == nil && kind >= len(typeTermKinds) {
+		r.err = fmt.Errorf("unknown type kind %d", kind)
+	}
+	if r.err != nil {
+		return typeTerm{}
+	}
+
+	t := typeTerm{typeTermKinds[kind], r.string(), nil}
+	for n := r.uint(); n > 0 && r.err == nil; n-- {
+		t.Args = append(t.Args, r.typeTerm())
+	}
+
+	return t
+}
+
+func (r *bytecodeReader) insts() []bytecodeInst {
+	code := make([]bytecodeInst, 0)
+	for n := r.uint(); n > 0 && r.err == nil; n-- {
+		op := r.uint()
+		if r.err == nil && op >= len(bytecodeOps) {
+			r.err = fmt.Errorf("unknown opcode %d", op)
+		}
+		if r.err != nil {
+			break
+		}
+
+		i := bytecodeInst{Op: bytecodeOps[op]}
+		for k := 0; k < bytecodeArgCounts[i.Op]; k++ {
+			i.Args = append(i.Args, r.int())
+		}
+
+		switch i.Op {
+		case "PushGlobal":
+			i.Name = r.string()
+		case "BinOp":
+			i.Pos = &bytecodePos{r.string(), r.uint(), r.uint()}
+		case "Jump":
+			for k := r.uint(); k > 0 && r.err == nil; k-- {
+				i.Branches = append(i.Branches, r.insts())
+			}
+			i.Tags = make(map[int]int)
+			for k := r.uint(); k > 0 && r.err == nil; k-- {
+				tag := r.int()
+				i.Tags[tag] = r.uint()
+			}
+		}
+		code = append(code, i)
+	}
+
+	return code
+}
@@ -0,0 +1,596 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+type outputFormat = int
+
+const (
+	formatText outputFormat = iota
+	formatJSON
+	formatBinary
+)
+
+// Flags a command accepts besides -format
+type commandFlags = int
+
+const (
+	flagEntry commandFlags = 1 << iota
+	flagTrace
+	flagRun
+	flagOutput
+	flagBinary
+	flagBench
+	flagBuild
+	flagGo
+	flagDiff
+)
+
+type commandOptions struct {
+	entry    string
+	entrySet bool
+	trace    traceLevel
+	format   outputFormat
+	overflow overflowMode
+	depth    int
+	output   string
+	interp   interpreter
+	runs     int
+	target   buildTarget
+	cc       string
+	llc      string
+	wat2wasm string
+	emit     bool
+	runtime  string
+	pkg      string
+}
+
+func (opts *commandOptions) runOptions() runOptions {
+	return runOptions{opts.trace, opts.overflow, opts.depth, opts.interp}
+}
+
+// Results of a command are written to stdout and diagnostics to stderr, so
+// the tool can be used from scripts
+type command struct {
+	name    string
+	args    []string
+	summary string
+	flags   commandFlags
+	run     func(opts *commandOptions, args []string) error
+}
+
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{"check", []string{"file", "[arg...]"}, "typecheck a program", flagEntry | flagTrace, checkCommand},
+		{"compile", []string{"file", "[arg...]"}, "compile a program and emit its G-code", flagEntry | flagTrace | flagOutput | flagBinary, compileCommand},
+		{"run", []string{"file", "[arg...]"}, "compile a program and print the value of its entry point", flagEntry | flagTrace | flagRun, runCommand},
+		{"exec", []string{"file", "[arg...]"}, "run a program written by compile -format binary or json", flagEntry | flagTrace | flagRun, execCommand},
+		{"bench", []string{"file", "[arg...]"}, "compare the running time of the interpreters", flagEntry | flagRun | flagBench, benchCommand},
+		{"build", []string{"file", "[arg...]"}, "compile a program to C, LLVM IR or WebAssembly", flagEntry | flagOutput | flagBuild, buildCommand},
+		{"gen-go", []string{"file", "[arg...]"}, "compile a program to a Go package", flagEntry | flagOutput | flagGo, genGoCommand},
+		{"difftest", []string{"file", "[file...]"}, "compare the results of gVM with the reference interpreter", flagEntry | flagDiff, difftestCommand},
+		{"repl", []string{"[file...]"}, "start an interactive session", flagTrace | flagRun, replCommand},
+		{"dump", []string{"ast|types|gcode|groups", "file"}, "print an intermediate representation of a program", flagEntry | flagTrace, dumpCommand},
+	}
+}
+
+func lookupCommand(name string) *command {
+	for _, c := range commands {
+		if c.name == name {
+			return c
+		}
+	}
+
+	return nil
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: fngo <command> [flags] <args>")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-8s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run 'fngo <command> -h' for the flags of a command.")
+}
+
+// Run the command named by the first argument and return the exit status
+func dispatch(args []string) int {
+	if len(args) == 0 {
+		usage(os.Stderr)
+		return 2
+	}
+	if args[0] == "help" || args[0] == "-h" || args[0] == "-help" {
+		usage(os.Stdout)
+		return 0
+	}
+
+	c := lookupCommand(args[0])
+	if c == nil {
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n\n", args[0])
+		usage(os.Stderr)
+		return 2
+	}
+
+	opts, rest, err := c.parse(args[1:])
+	if err == flag.ErrHelp {
+		return 0
+	}
+	if err != nil {
+		return 2
+	}
+
+	err = c.run(opts, rest)
+	if err != nil {
+		reportError(opts, err)
+		return 1
+	}
+
+	return 0
+}
+
+func (c *command) parse(args []string) (*commandOptions, []string, error) {
+	fs := flag.NewFlagSet(c.name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: fngo %s [flags] %s\n", c.name, strings.Join(c.args, " "))
+		fs.PrintDefaults()
+	}
+
+	opts := &commandOptions{entry: "main", depth: defaultPrintDepth, runs: 10, cc: "gcc", llc: "llc", wat2wasm: "wat2wasm", pkg: "main"}
+	formatUsage := "output format: text or json"
+	if c.flags&flagBinary != 0 {
+		formatUsage = "output format: text, json or binary"
+	}
+	format := fs.String("format", "text", formatUsage)
+	trace := "result"
+	overflow := "wrap"
+	interp := "dense"
+	target := "c"
+	if c.flags&flagEntry != 0 {
+		fs.StringVar(&opts.entry, "entry", opts.entry, "name of the entry point")
+	}
+	if c.flags&flagTrace != 0 {
+		fs.StringVar(&trace, "trace", trace, "trace level: result, inst or state")
+	}
+	if c.flags&(flagRun|flagDiff) != 0 {
+		fs.StringVar(&overflow, "overflow", overflow, "integer overflow behaviour: wrap, trap or bignum")
+	}
+	if c.flags&(flagRun|flagBuild|flagGo|flagDiff) != 0 {
+		fs.IntVar(&opts.depth, "depth", opts.depth, "depth to which the result is evaluated and printed")
+	}
+	if c.flags&(flagRun|flagDiff) != 0 && c.flags&flagBench == 0 {
+		fs.StringVar(&interp, "vm", interp, "interpreter: dense or inst")
+	}
+	if c.flags&flagBench != 0 {
+		fs.IntVar(&opts.runs, "n", opts.runs, "number of runs with each interpreter")
+	}
+	if c.flags&flagBuild != 0 {
+		fs.StringVar(&target, "target", target, "target: c, llvm or wat")
+		fs.StringVar(&opts.cc, "cc", opts.cc, "C compiler")
+		fs.StringVar(&opts.llc, "llc", opts.llc, "LLVM compiler for the llvm target")
+		fs.StringVar(&opts.wat2wasm, "wat2wasm", opts.wat2wasm, "assembler for the wat target")
+		fs.BoolVar(&opts.emit, "emit", false, "write the C source, LLVM IR or WebAssembly text instead of building")
+		fs.StringVar(&opts.runtime, "runtime", "", "write the runtime to link with the LLVM IR to file")
+	}
+	if c.flags&flagGo != 0 {
+		fs.StringVar(&opts.pkg, "package", opts.pkg, "name of the package, which builds to an executable if it is main")
+	}
+	if c.flags&flagOutput != 0 {
+		outputUsage := "write output to file instead of stdout"
+		if c.flags&flagBuild != 0 {
+			outputUsage = "executable to build, named after the file by default"
+		}
+		fs.StringVar(&opts.output, "o", "", outputUsage)
+	}
+
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, nil, err
+	}
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "entry" {
+			opts.entrySet = true
+		}
+	})
+	if !c.acceptsArgs(fs.NArg()) {
+		fs.Usage()
+		return nil, nil, fmt.Errorf("wrong number of arguments")
+	}
+
+	opts.format, err = parseOutputFormat(*format)
+	if err == nil && opts.format == formatBinary && c.flags&flagBinary == 0 {
+		err = fmt.Errorf("Binary output is not supported by %s", c.name)
+	}
+	if err == nil {
+		opts.trace, err = parseTraceLevel(trace)
+	}
+	if err == nil {
+		opts.overflow, err = parseOverflowMode(overflow)
+	}
+	if err == nil {
+		opts.interp, err = parseInterpreter(interp)
+	}
+	if err == nil {
+		opts.target, err = parseBuildTarget(target)
+	}
+	if err != nil {
+		fmt.Fprintln(fs.Output(), err)
+		fs.Usage()
+		return nil, nil, err
+	}
+
+	return opts, fs.Args(), nil
+}
+
+// The last argument of a command may be repeated when it ends in "..."
+func (c *command) acceptsArgs(n int) bool {
+	if strings.HasSuffix(c.args[len(c.args)-1], "...]") {
+		return n >= len(c.args)-1
+	}
+
+	return n == len(c.args)
+}
+
+func parseOutputFormat(s string) (outputFormat, error) {
+	switch s {
+	case "text":
+		return formatText, nil
+	case "json":
+		return formatJSON, nil
+	case "binary":
+		return formatBinary, nil
+	default:
+		return formatText, fmt.Errorf("Unknown output format: %s", s)
+	}
+}
+
+func parseTraceLevel(s string) (traceLevel, error) {
+	switch s {
+	case "result":
+		return traceResult, nil
+	case "inst":
+		return traceInst, nil
+	case "state":
+		return traceState, nil
+	default:
+		return traceResult, fmt.Errorf("Unknown trace level: %s", s)
+	}
+}
+
+// Errors are printed to stderr, unless JSON output is requested
+func reportError(opts *commandOptions, err error) {
+	if opts.format == formatJSON {
+		writeJSON(os.Stdout, struct {
+			Error string `json:"error"`
+		}{err.Error()})
+		return
+	}
+
+	fmt.Fprintln(os.Stderr, err)
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// Print definition groups and their types in typechecking order
+func traceGroups(w io.Writer, prog []definition, mgr *typMgr, e *typEnv) {
+	for i, g := range dependencyGroups(programDefns(prog)) {
+		fmt.Fprintf(w, "group %d: %v\n", i, g)
+		for _, d := range g.members {
+			// Lifted lambdas are not in the environment
+			if s := e.lookup(d.name); s != nil {
+				fmt.Fprintf(w, "\t%s : %s\n", d.name, s.typString(mgr))
+			}
+		}
+	}
+}
+
+func checkCommand(opts *commandOptions, args []string) error {
+	p, err := checkFile(args[0])
+	if err != nil {
+		return err
+	}
+	if opts.trace >= traceInst {
+		traceGroups(os.Stderr, p.defs, p.mgr, p.env)
+	}
+
+	err = p.setEntry(opts.entry, args[1:])
+	if err != nil {
+		return err
+	}
+
+	if opts.format == formatJSON {
+		return writeJSON(os.Stdout, struct {
+			Entry string `json:"entry"`
+			Type  string `json:"type"`
+		}{opts.entry, p.entry.typ.typString(p.mgr)})
+	}
+
+	return nil
+}
+
+func compileCommand(opts *commandOptions, args []string) error {
+	p, err := checkFile(args[0])
+	if err != nil {
+		return err
+	}
+	if opts.trace >= traceInst {
+		traceGroups(os.Stderr, p.defs, p.mgr, p.env)
+	}
+
+	err = p.setEntry(opts.entry, args[1:])
+	if err != nil {
+		return err
+	}
+	err = p.compile()
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if opts.output != "" {
+		file, err := os.Create(opts.output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	return writeCompiled(w, opts.format, p)
+}
+
+// The G-code listing, or the bytecode of the program in the JSON and binary
+// formats, which exec runs
+func writeCompiled(w io.Writer, format outputFormat, p *program) error {
+	if format == formatText {
+		return writeGCode(w, programGlobals(p.defs))
+	}
+
+	return writeBytecode(w, format, newBytecode(p))
+}
+
+func runCommand(opts *commandOptions, args []string) error {
+	p, err := checkFile(args[0])
+	if err != nil {
+		return err
+	}
+	err = p.setEntry(opts.entry, args[1:])
+	if err != nil {
+		return err
+	}
+	err = p.compile()
+	if err != nil {
+		return err
+	}
+
+	return runAndPrint(p, programGlobals(p.defs), opts)
+}
+
+// The program runs from the entry point it was compiled with, unless another
+// one or other arguments are given
+func execCommand(opts *commandOptions, args []string) error {
+	p, globals, err := loadBytecode(args[0])
+	if err != nil {
+		return err
+	}
+
+	if opts.entrySet || len(args) > 1 {
+		entry := p.entry.name
+		if opts.entrySet {
+			entry = opts.entry
+		}
+		err = p.setCompiledEntry(globals, entry, args[1:])
+		if err != nil {
+			return err
+		}
+		err = p.compile()
+		if err != nil {
+			return err
+		}
+		for _, d := range programDefns(p.defs) {
+			globals = append(globals, global{d.name, len(d.params), d.instructions})
+		}
+	}
+
+	return runAndPrint(p, globals, opts)
+}
+
+func runAndPrint(p *program, globals []global, opts *commandOptions) error {
+	result, vm, err := runProgram(p, globals, opts.runOptions())
+	if err != nil {
+		return errors.Wrap(err, "Runtime Error")
+	}
+	if opts.trace >= traceInst {
+		fmt.Fprintln(os.Stderr, "GC:", vm.heap.Stats)
+	}
+
+	if opts.format == formatJSON {
+		return writeJSON(os.Stdout, struct {
+			Entry  string `json:"entry"`
+			Type   string `json:"type"`
+			Result string `json:"result"`
+		}{p.entry.name, p.entry.typ.typString(p.mgr), result})
+	}
+
+	fmt.Println(result)
+	return nil
+}
+
+func dumpCommand(opts *commandOptions, args []string) error {
+	switch args[0] {
+	case "ast":
+		prog, err := loadProgram(args[1])
+		if err != nil {
+			return err
+		}
+		return writeAST(os.Stdout, opts.format, prog)
+	case "groups":
+		prog, err := loadProgram(args[1])
+		if err != nil {
+			return err
+		}
+		return writeGroups(os.Stdout, opts.format, dependencyGroups(programDefns(prog)))
+	case "types":
+		p, err := checkFile(args[1])
+		if err != nil {
+			return err
+		}
+		if opts.trace >= traceInst {
+			traceGroups(os.Stderr, p.defs, p.mgr, p.env)
+		}
+		return writeTypes(os.Stdout, opts.format, p.defs, p.mgr, p.env)
+	case "gcode":
+		p, err := checkFile(args[1])
+		if err != nil {
+			return err
+		}
+		if opts.trace >= traceInst {
+			traceGroups(os.Stderr, p.defs, p.mgr, p.env)
+		}
+		err = p.setEntry(opts.entry, nil)
+		if err != nil {
+			return err
+		}
+		err = p.compile()
+		if err != nil {
+			return err
+		}
+		return writeCompiled(os.Stdout, opts.format, p)
+	default:
+		return fmt.Errorf("Unknown representation: %s (expected ast, types, gcode or groups)", args[0])
+	}
+}
+
+func writeAST(w io.Writer, format outputFormat, prog []definition) error {
+	type jsonDefinition struct {
+		Kind     string `json:"kind"`
+		Name     string `json:"name"`
+		Position string `json:"position"`
+		Source   string `json:"source"`
+	}
+
+	defs := make([]jsonDefinition, 0)
+	for _, d := range prog {
+		switch def := d.(type) {
+		case *definitionDefn:
+			defs = append(defs, jsonDefinition{"defn", def.name, def.pos.String(), def.String()})
+		case *definitionData:
+			defs = append(defs, jsonDefinition{"data", def.name, def.pos.String(), def.String()})
+		}
+	}
+
+	if format == formatJSON {
+		return writeJSON(w, defs)
+	}
+	for _, d := range defs {
+		fmt.Fprintln(w, d.Source)
+	}
+	return nil
+}
+
+func writeGroups(w io.Writer, format outputFormat, groups []defnGroup) error {
+	names := make([][]string, 0)
+	for _, g := range groups {
+		members := make([]string, 0)
+		for _, d := range g.members {
+			members = append(members, d.name)
+		}
+		names = append(names, members)
+	}
+
+	if format == formatJSON {
+		return writeJSON(w, names)
+	}
+	for i, g := range groups {
+		fmt.Fprintf(w, "group %d: %v\n", i, g)
+	}
+	return nil
+}
+
+func writeTypes(w io.Writer, format outputFormat, prog []definition, mgr *typMgr, e *typEnv) error {
+	type jsonType struct {
+		Kind string `json:"kind"`
+		Name string `json:"name"`
+		Type string `json:"type"`
+	}
+
+	types := make([]jsonType, 0)
+	for _, d := range prog {
+		switch def := d.(type) {
+		case *definitionDefn:
+			types = append(types, jsonType{"defn", def.name, e.lookup(def.name).typString(mgr)})
+		case *definitionData:
+			for _, c := range def.constructors {
+				types = append(types, jsonType{"constructor", c.name, e.lookup(c.name).typString(mgr)})
+			}
+		}
+	}
+
+	if format == formatJSON {
+		return writeJSON(w, types)
+	}
+	for _, t := range types {
+		fmt.Fprintf(w, "%s : %s\n", t.Name, t.Type)
+	}
+	return nil
+}
+
+func writeGCode(w io.Writer, globals []global) error {
+	for _, g := range globals {
+		fmt.Fprintf(w, "%s/%d:\n", g.name, g.arity)
+		for _, line := range codeLines(g.code, "") {
+			fmt.Fprintf(w, "\t%s\n", line)
+		}
+		fmt.Fprintln(w)
+	}
+	return nil
+}
+
+// Listing of code with the branches of jumps indented under the tags which
+// select them
+func codeLines(code []inst, indent string) []string {
+	lines := make([]string, 0)
+	for _, i := range code {
+		jump, ok := i.(instJump)
+		if !ok {
+			lines = append(lines, indent+i.String())
+			continue
+		}
+
+		lines = append(lines, indent+"Jump")
+		for b, branch := range jump.branches {
+			tags := make([]int, 0)
+			for tag, target := range jump.tagMappings {
+				if target == b {
+					tags = append(tags, tag)
+				}
+			}
+			sort.Ints(tags)
+			tagNames := make([]string, 0)
+			for _, tag := range tags {
+				tagNames = append(tagNames, fmt.Sprintf("%d", tag))
+			}
+
+			lines = append(lines, fmt.Sprintf("%s  case %s:", indent, strings.Join(tagNames, ", ")))
+			lines = append(lines, codeLines(branch, indent+"    ")...)
+		}
+	}
+
+	return lines
+}
@@ -15,7 +15,7 @@ func (a astLID) compile(e compEnv, into *[]inst) error {
 	if e.hasVariable(a.ID) {
 		idOffset, err := e.getOffset(a.ID)
 		if err != nil {
-			return err
+			return atPosition(a.pos, err)
 		}
 		*into = append(*into, instPush{idOffset})
 	} else {
@@ -43,7 +43,7 @@ func (a astBinOp) compile(e compEnv, into *[]inst) error {
 		return err
 	}
 	*into = append(*into, instEval{})
-	*into = append(*into, instBinOp{a.op})
+	*into = append(*into, instBinOp{a.op, a.pos})
 
 	return nil
 }
@@ -73,10 +73,10 @@ func (b constructor) compile(e compEnv, into *[]inst) error {
 }
 
 func (a astCase) compile(e compEnv, into *[]inst) error {
-	ty, ok := a.of.getNodeType().(*typData)
+	ty, ok := dataTypeOf(a.of.getNodeType())
 
 	if !ok {
-		return fmt.Errorf("Unexpected case of type %s", reflect.TypeOf(a.of))
+		return atPosition(a.pos, fmt.Errorf("Unexpected case of type %s", reflect.TypeOf(a.of)))
 	}
 
 	err := a.of.compile(e, into)
@@ -91,12 +91,16 @@ func (a astCase) compile(e compEnv, into *[]inst) error {
 
 	for _, branch := range a.branches {
 		branchInst := make([]inst, 0)
-		if _, ok := branch.pat.(patternVar); ok {
-			branch.expr.compile(compEnvOffset{1, e}, &branchInst)
+		if vpat, ok := branch.pat.(patternVar); ok {
+			err := branch.expr.compile(compEnvVar{vpat.variable, e}, &branchInst)
+			if err != nil {
+				return err
+			}
+			branchInst = append(branchInst, instSlide{1})
 
 			for _, constPair := range ty.constructors {
 				if _, ok := jmpInst.tagMappings[constPair.tag]; ok {
-					break
+					continue
 				}
 
 				jmpInst.tagMappings[constPair.tag] = len(jmpInst.branches)
@@ -110,13 +114,16 @@ func (a astCase) compile(e compEnv, into *[]inst) error {
 			}
 
 			branchInst = append(branchInst, instSplit{})
-			branch.expr.compile(newEnv, &branchInst)
+			err := branch.expr.compile(newEnv, &branchInst)
+			if err != nil {
+				return err
+			}
 			branchInst = append(branchInst, instSlide{len(cpat.params)})
 
 			newTag := ty.constructors[cpat.constr].tag
 
 			if _, ok := jmpInst.tagMappings[newTag]; ok {
-				return fmt.Errorf("Technically not a type error: duplicate pattern")
+				return atPosition(cpat.pos, fmt.Errorf("Technically not a type error: duplicate pattern"))
 			}
 
 			jmpInst.tagMappings[newTag] = len(jmpInst.branches)
@@ -126,7 +133,7 @@ func (a astCase) compile(e compEnv, into *[]inst) error {
 
 	for _, constPair := range ty.constructors {
 		if _, ok := jmpInst.tagMappings[constPair.tag]; !ok {
-			return fmt.Errorf("Non total pattern")
+			return atPosition(a.pos, fmt.Errorf("Non total pattern"))
 		}
 	}
 
@@ -135,6 +142,74 @@ func (a astCase) compile(e compEnv, into *[]inst) error {
 	return nil
 }
 
+func (a astLet) compile(e compEnv, into *[]inst) error {
+	n := len(a.bindings)
+	var newEnv compEnv = e
+
+	if a.rec {
+		for _, b := range a.bindings {
+			newEnv = compEnvVar{b.name, newEnv}
+		}
+
+		*into = append(*into, instAlloc{n})
+		for i, b := range a.bindings {
+			err := b.expr.compile(newEnv, into)
+			if err != nil {
+				return err
+			}
+			*into = append(*into, instUpdate{n - 1 - i})
+		}
+	} else {
+		for _, b := range a.bindings {
+			err := b.expr.compile(newEnv, into)
+			if err != nil {
+				return err
+			}
+			newEnv = compEnvVar{b.name, newEnv}
+		}
+	}
+
+	err := a.body.compile(newEnv, into)
+	if err != nil {
+		return err
+	}
+	*into = append(*into, instSlide{n})
+
+	return nil
+}
+
+// Jump on the tag of the evaluated Bool, dropping it before either branch
+func (a astIf) compile(e compEnv, into *[]inst) error {
+	err := a.cond.compile(e, into)
+	if err != nil {
+		return err
+	}
+	*into = append(*into, instEval{})
+
+	thenInst := []inst{instPop{1}}
+	err = a.thenExpr.compile(e, &thenInst)
+	if err != nil {
+		return err
+	}
+
+	elseInst := []inst{instPop{1}}
+	err = a.elseExpr.compile(e, &elseInst)
+	if err != nil {
+		return err
+	}
+
+	*into = append(*into, instJump{
+		[][]inst{thenInst, elseInst},
+		map[int]int{boolTrueTag: 0, boolFalseTag: 1},
+	})
+
+	return nil
+}
+
+func (a astLambda) compile(e compEnv, into *[]inst) error {
+	return atPosition(a.pos, fmt.Errorf("Lambda should be lifted before compile: %v", a))
+}
+
 func (a *definitionData) compile() error {
 	return nil
 }
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type (
+	defnGroup struct {
+		members []*definitionDefn
+	}
+
+	// Tarjan's strongly connected components over the call graph
+	sccFinder struct {
+		edges   map[string][]string
+		index   map[string]int
+		lowlink map[string]int
+		onStack map[string]bool
+		stack   []string
+		next    int
+		groups  [][]string
+	}
+)
+
+func (g defnGroup) String() string {
+	names := make([]string, len(g.members))
+	for i, d := range g.members {
+		names[i] = d.name
+	}
+
+	return fmt.Sprintf("{ %s }", strings.Join(names, ", "))
+}
+
+func programDefns(prg []definition) []*definitionDefn {
+	result := make([]*definitionDefn, 0)
+	for _, d := range prg {
+		if defn, ok := d.(*definitionDefn); ok {
+			result = append(result, defn)
+		}
+	}
+
+	return result
+}
+
+// Names of the other definitions the body of d refers to
+func (d *definitionDefn) dependencies(defns map[string]*definitionDefn) []string {
+	free := make(map[string]bool)
+	d.body.freeVariables(extendLocals(nil, d.params), free)
+
+	result := make([]string, 0)
+	for name := range free {
+		if _, ok := defns[name]; ok {
+			result = append(result, name)
+		}
+	}
+	sort.Strings(result)
+
+	return result
+}
+
+// Split definitions into mutually recursive groups, ordered so that every
+// group comes after the groups it depends on.
+func dependencyGroups(defns []*definitionDefn) []defnGroup {
+	byName := make(map[string]*definitionDefn, len(defns))
+	for _, d := range defns {
+		byName[d.name] = d
+	}
+
+	f := &sccFinder{
+		make(map[string][]string),
+		make(map[string]int),
+		make(map[string]int),
+		make(map[string]bool),
+		make([]string, 0),
+		0,
+		make([][]string, 0),
+	}
+	for _, d := range defns {
+		f.edges[d.name] = d.dependencies(byName)
+	}
+
+	for _, d := range defns {
+		if _, ok := f.index[d.name]; !ok {
+			f.visit(d.name)
+		}
+	}
+
+	result := make([]defnGroup, 0, len(f.groups))
+	for _, names := range f.groups {
+		members := make([]*definitionDefn, 0, len(names))
+		for _, d := range defns {
+			for _, n := range names {
+				if d.name == n {
+					members = append(members, d)
+				}
+			}
+		}
+		result = append(result, defnGroup{members})
+	}
+
+	return result
+}
+
+func (f *sccFinder) visit(v string) {
+	f.index[v] = f.next
+	f.lowlink[v] = f.next
+	f.next++
+	f.stack = append(f.stack, v)
+	f.onStack[v] = true
+
+	for _, w := range f.edges[v] {
+		if _, ok := f.index[w]; !ok {
+			f.visit(w)
+			if f.lowlink[w] < f.lowlink[v] {
+				f.lowlink[v] = f.lowlink[w]
+			}
+		} else if f.onStack[w] && f.index[w] < f.lowlink[v] {
+			f.lowlink[v] = f.index[w]
+		}
+	}
+
+	if f.lowlink[v] != f.index[v] {
+		return
+	}
+
+	group := make([]string, 0)
+	for {
+		w := f.stack[len(f.stack)-1]
+		f.stack = f.stack[:len(f.stack)-1]
+		f.onStack[w] = false
+		group = append(group, w)
+		if w == v {
+			break
+		}
+	}
+	f.groups = append(f.groups, group)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// Run every program with gVM and with the reference interpreter, and fail if
+// any of them prints different results
+func difftestCommand(opts *commandOptions, args []string) error {
+	failed := 0
+	for _, path := range args {
+		same, err := difftest(opts, path)
+		if err != nil {
+			return errors.Wrap(err, path)
+		}
+		if !same {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d programs differ", failed, len(args))
+	}
+
+	return nil
+}
+
+func difftest(opts *commandOptions, path string) (bool, error) {
+	got, want, err := difftestOutcomes(path, opts.entry, runOptions{traceResult, opts.overflow, opts.depth, opts.interp})
+	if err != nil {
+		return false, err
+	}
+
+	if got != want {
+		fmt.Printf("FAIL %s\n\tgvm: %s\n\tref: %s\n", path, got, want)
+		return false, nil
+	}
+	fmt.Printf("ok   %s\n", path)
+
+	return true, nil
+}
+
+// Outcomes of a program run with gVM and with the reference interpreter
+func difftestOutcomes(path string, entry string, opts runOptions) (string, string, error) {
+	p, err := checkFile(path)
+	if err != nil {
+		return "", "", err
+	}
+	err = p.setEntry(entry, nil)
+	if err != nil {
+		return "", "", err
+	}
+
+	result, err := runReference(p, opts.depth, opts.overflow)
+	want := outcome(result, err)
+
+	err = p.compile()
+	if err != nil {
+		return "", "", err
+	}
+	result, _, err = runProgram(p, programGlobals(p.defs), opts)
+
+	return outcome(result, err), want, nil
+}
+
+// The printed result, or the error without the context gVM adds to it
+func outcome(result string, err error) string {
+	if err != nil {
+		return "error: " + errors.Cause(err).Error()
+	}
+
+	return result
+}
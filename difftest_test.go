@@ -0,0 +1,36 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// Programs every backend is tested on, together with the samples
+func corpus(t testing.TB) []string {
+	paths, err := filepath.Glob(filepath.Join("testdata", "corpus", "*.fn"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("empty corpus")
+	}
+
+	return append(paths, "sample.text", "bench.text")
+}
+
+func TestDifftest(t *testing.T) {
+	for _, path := range corpus(t) {
+		for _, overflow := range []overflowMode{overflowWrap, overflowTrap, overflowBignum} {
+			for _, interp := range []interpreter{interpDense, interpInst} {
+				got, want, err := difftestOutcomes(path, "main", runOptions{traceResult, overflow, 8, interp})
+				if err != nil {
+					t.Errorf("%s: %v", path, err)
+					continue
+				}
+				if got != want {
+					t.Errorf("%s, overflow mode %d, interpreter %d:\n\tgvm: %s\n\tref: %s", path, overflow, interp, got, want)
+				}
+			}
+		}
+	}
+}
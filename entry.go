@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"text/scanner"
+
+	"github.com/pkg/errors"
+)
+
+// The global a program starts from, applied to the arguments given on the
+// command line
+type entryPoint struct {
+	name string
+	args []string
+	typ  typ
+}
+
+// Every argument is parsed as an expression and becomes a global without
+// parameters, so that it is typechecked, lifted and compiled like the rest of
+// the program and may refer to its definitions. The language has no unary
+// minus, but a negative number on its own is accepted.
+func parseEntryArgs(texts []string) ([]*definitionDefn, error) {
+	args := make([]*definitionDefn, 0)
+	for i, text := range texts {
+		name := fmt.Sprintf("<arg %d>", i+1)
+		text = strings.TrimSpace(text)
+		negative := strings.HasPrefix(text, "-")
+
+		var body ast
+		if negative {
+			pos := scanner.Position{Filename: name, Line: 1, Column: 1}
+			n, err := strconv.Atoi(text)
+			if numErr, ok := err.(*strconv.NumError); ok && numErr.Err == strconv.ErrRange {
+				return nil, syntaxErrors{{pos, "", nil, fmt.Sprintf("integer literal out of range: %s", text)}}
+			}
+			if err == nil {
+				body = &astInt{n, nil, pos}
+			}
+		}
+		if body == nil {
+			var err error
+			body, err = parseExpression(name, strings.NewReader(text))
+			if err != nil && negative {
+				return nil, errors.Wrap(err, "write negative numbers in expressions as (0 - n)")
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		args = append(args, newDefinitionDefn(fmt.Sprintf("$arg%d", i), make([]string, 0), body, body.getPosition()))
+	}
+
+	return args, nil
+}
+
+// The entry point must be a global with a type, which lifted lambdas do not
+// have
+func checkEntry(globals []global, e *typEnv, name string) error {
+	for _, g := range globals {
+		if g.name == name && e.lookup(name) != nil {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("Undefined entry point: %s", name)
+}
+
+// Typecheck the arguments against the type of the entry point and add them
+// to the program
+func (p *program) setEntry(name string, argTexts []string) error {
+	return p.setCompiledEntry(programGlobals(p.defs), name, argTexts)
+}
+
+// Set the entry point of a program whose globals are already compiled, such
+// as one read from a file. Only the arguments still need compiling.
+func (p *program) setCompiledEntry(globals []global, name string, argTexts []string) error {
+	err := checkEntry(globals, p.env, name)
+	if err != nil {
+		return err
+	}
+	args, err := parseEntryArgs(argTexts)
+	if err != nil {
+		return err
+	}
+
+	for _, a := range args {
+		a.typecheckFirst(p.mgr, p.env)
+		err := a.typecheckSecond(p.mgr, p.env)
+		if err != nil {
+			return errors.Wrap(err, "Typecheck Error")
+		}
+		a.generalize(p.mgr, p.env)
+	}
+
+	t := p.env.lookup(name).instantiate(p.mgr)
+	for i, a := range args {
+		result := p.mgr.newTyp()
+		err := p.mgr.unify(&typArr{p.env.lookup(a.name).instantiate(p.mgr), result}, t)
+		if err != nil {
+			err = errors.Wrap(err, fmt.Sprintf("argument %d of %s", i+1, name))
+			return errors.Wrap(atPosition(a.pos, err), "Typecheck Error")
+		}
+		t = result
+	}
+
+	var v *typVar
+	if _, ok := p.mgr.resolve(t, &v).(*typArr); ok {
+		return fmt.Errorf("Entry point %s is still a function after %d arguments: %s", name, len(args), t.typString(p.mgr))
+	}
+
+	argNames := make([]string, 0)
+	for _, a := range args {
+		err := a.resolve(p.mgr)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("resolving: %v", a))
+		}
+		p.defs = append(p.defs, a)
+		argNames = append(argNames, a.name)
+	}
+	p.entry = &entryPoint{name, argNames, t}
+
+	return nil
+}
+
+// Build the application of a global to the argument globals, the same
+// spine MkApp builds for a call
+func (g *gVM) apply(name string, args []string) (addrType, error) {
+	f, ok := g.globalMap[name]
+	if !ok {
+		return 0, fmt.Errorf("Undefined function: %s", name)
+	}
+
+	for _, arg := range args {
+		a, ok := g.globalMap[arg]
+		if !ok {
+			return 0, fmt.Errorf("Undefined function: %s", arg)
+		}
+		f = g.heap.Alloc(&nodeApp{Left: f, Right: a})
+	}
+
+	return f, nil
+}
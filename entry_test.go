@@ -0,0 +1,33 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseEntryArgs(t *testing.T) {
+	args, err := parseEntryArgs([]string{"-3", " -9223372036854775808", "(0 - 3) + 1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"-3", "-9223372036854775808", "0 - 3 + 1"}
+	for i, a := range args {
+		if got := a.body.String(); got != want[i] {
+			t.Errorf("argument %d is %s, want %s", i+1, got, want[i])
+		}
+	}
+
+	cases := []struct {
+		text string
+		err  string
+	}{
+		{"-3 + 1", "write negative numbers in expressions as (0 - n): <arg 1>:1:1: syntax error: unexpected \"-\""},
+		{"-99999999999999999999", "<arg 1>:1:1: integer literal out of range: -99999999999999999999"},
+	}
+	for _, c := range cases {
+		_, err := parseEntryArgs([]string{c.text})
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%q: got error %v, want %q", c.text, err, c.err)
+		}
+	}
+}
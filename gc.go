@@ -0,0 +1,25 @@
+package main
+
+// Run collector when enough nodes are allocated since the last collection.
+// Must only be called between instructions, when every reachable address is
+// on the stack, in the dump or in the globalMap.
+func (g *gVM) maybeCollect() {
+	if !g.heap.Due() {
+		return
+	}
+
+	g.collect()
+}
+
+func (g *gVM) collect() {
+	roots := make([]addrType, 0)
+	roots = append(roots, g.stack.Data...)
+	for _, de := range g.dump {
+		roots = append(roots, de.stack.Data...)
+	}
+	for _, a := range g.globalMap {
+		roots = append(roots, a)
+	}
+
+	g.heap.Collect(roots)
+}
@@ -2,12 +2,13 @@ package main
 
 import (
 	"fmt"
+	"text/scanner"
 )
 
 type (
 	inst interface {
 		fmt.Stringer
-		execute(g *gVM)
+		execute(g *gVM) error
 	}
 
 	instPushInt struct {
@@ -49,7 +50,8 @@ type (
 	}
 
 	instBinOp struct {
-		op binOpType
+		op  binOpType
+		pos scanner.Position
 	}
 
 	instEval struct{}
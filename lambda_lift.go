@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+type lambdaLifter struct {
+	owner string
+	count int
+	defns []definition
+}
+
+// Lift every lambda in prg into a new top level definition, which takes the
+// free variables of the lambda as extra leading parameters.
+func liftLambdas(prg []definition) []definition {
+	l := &lambdaLifter{
+		"",
+		0,
+		make([]definition, 0),
+	}
+
+	for _, d := range prg {
+		defn, ok := d.(*definitionDefn)
+		if !ok {
+			continue
+		}
+
+		l.owner = defn.name
+		l.count = 0
+		defn.body = defn.body.lift(l, extendLocals(nil, defn.params))
+	}
+
+	return append(prg, l.defns...)
+}
+
+func (l *lambdaLifter) newName() string {
+	name := fmt.Sprintf("%s$lambda%d", l.owner, l.count)
+	l.count++
+
+	return name
+}
+
+func extendLocals(locals map[string]bool, names []string) map[string]bool {
+	result := make(map[string]bool, len(locals)+len(names))
+	for n := range locals {
+		result[n] = true
+	}
+	for _, n := range names {
+		result[n] = true
+	}
+
+	return result
+}
+
+// Lambda Lifting
+func (a *astInt) lift(l *lambdaLifter, locals map[string]bool) ast {
+	return a
+}
+
+func (a *astLID) lift(l *lambdaLifter, locals map[string]bool) ast {
+	return a
+}
+
+func (a *astUID) lift(l *lambdaLifter, locals map[string]bool) ast {
+	return a
+}
+
+func (a *astBinOp) lift(l *lambdaLifter, locals map[string]bool) ast {
+	a.left = a.left.lift(l, locals)
+	a.right = a.right.lift(l, locals)
+
+	return a
+}
+
+func (a *astApp) lift(l *lambdaLifter, locals map[string]bool) ast {
+	a.left = a.left.lift(l, locals)
+	a.right = a.right.lift(l, locals)
+
+	return a
+}
+
+func (a *astCase) lift(l *lambdaLifter, locals map[string]bool) ast {
+	a.of = a.of.lift(l, locals)
+	for i := 0; i < len(a.branches); i++ {
+		a.branches[i].lift(l, locals)
+	}
+
+	return a
+}
+
+func (b *branch) lift(l *lambdaLifter, locals map[string]bool) ast {
+	b.expr = b.expr.lift(l, extendLocals(locals, b.pat.variables()))
+
+	return b
+}
+
+func (a *astLet) lift(l *lambdaLifter, locals map[string]bool) ast {
+	if a.rec {
+		for _, b := range a.bindings {
+			locals = extendLocals(locals, []string{b.name})
+		}
+	}
+
+	for i := 0; i < len(a.bindings); i++ {
+		a.bindings[i].expr = a.bindings[i].expr.lift(l, locals)
+		if !a.rec {
+			locals = extendLocals(locals, []string{a.bindings[i].name})
+		}
+	}
+	a.body = a.body.lift(l, locals)
+
+	return a
+}
+
+func (a *astIf) lift(l *lambdaLifter, locals map[string]bool) ast {
+	a.cond = a.cond.lift(l, locals)
+	a.thenExpr = a.thenExpr.lift(l, locals)
+	a.elseExpr = a.elseExpr.lift(l, locals)
+
+	return a
+}
+
+func (a *astLambda) lift(l *lambdaLifter, locals map[string]bool) ast {
+	name := l.newName()
+	body := a.body.lift(l, extendLocals(locals, a.params))
+
+	free := make(map[string]bool)
+	body.freeVariables(extendLocals(nil, a.params), free)
+
+	captured := make([]string, 0)
+	for v := range free {
+		if locals[v] {
+			captured = append(captured, v)
+		}
+	}
+	sort.Strings(captured)
+
+	params := append(append([]string{}, captured...), a.params...)
+	l.defns = append(l.defns, newDefinitionDefn(name, params, body, a.pos))
+
+	var result ast = &astLID{name, nil, a.pos}
+	for _, v := range captured {
+		result = &astApp{result, &astLID{v, nil, a.pos}, nil, a.pos}
+	}
+	result.setNodeType(a.nodeTyp)
+
+	return result
+}
+
+// Free Variables
+func (a astInt) freeVariables(bound map[string]bool, into map[string]bool) {
+}
+
+func (a astLID) freeVariables(bound map[string]bool, into map[string]bool) {
+	if !bound[a.ID] {
+		into[a.ID] = true
+	}
+}
+
+func (a astUID) freeVariables(bound map[string]bool, into map[string]bool) {
+}
+
+func (a astBinOp) freeVariables(bound map[string]bool, into map[string]bool) {
+	a.left.freeVariables(bound, into)
+	a.right.freeVariables(bound, into)
+}
+
+func (a astApp) freeVariables(bound map[string]bool, into map[string]bool) {
+	a.left.freeVariables(bound, into)
+	a.right.freeVariables(bound, into)
+}
+
+func (a astCase) freeVariables(bound map[string]bool, into map[string]bool) {
+	a.of.freeVariables(bound, into)
+	for _, b := range a.branches {
+		b.freeVariables(bound, into)
+	}
+}
+
+func (b branch) freeVariables(bound map[string]bool, into map[string]bool) {
+	b.expr.freeVariables(extendLocals(bound, b.pat.variables()), into)
+}
+
+func (a astLet) freeVariables(bound map[string]bool, into map[string]bool) {
+	if a.rec {
+		for _, b := range a.bindings {
+			bound = extendLocals(bound, []string{b.name})
+		}
+	}
+
+	for _, b := range a.bindings {
+		b.expr.freeVariables(bound, into)
+		if !a.rec {
+			bound = extendLocals(bound, []string{b.name})
+		}
+	}
+	a.body.freeVariables(bound, into)
+}
+
+func (a astIf) freeVariables(bound map[string]bool, into map[string]bool) {
+	a.cond.freeVariables(bound, into)
+	a.thenExpr.freeVariables(bound, into)
+	a.elseExpr.freeVariables(bound, into)
+}
+
+func (a astLambda) freeVariables(bound map[string]bool, into map[string]bool) {
+	a.body.freeVariables(extendLocals(bound, a.params), into)
+}
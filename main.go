@@ -3,41 +3,127 @@ package main
 
 import (
 	"fmt"
-	"log"
 	"os"
 
 	"github.com/pkg/errors"
 )
 
-func typecheckProgram(prg []definition) error {
+// Parse a source file and prepend the builtin definitions
+func loadProgram(path string) ([]definition, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	prog, err := parseProgram(path, file)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(builtinDefinitions(), prog...), nil
+}
+
+// A typechecked program, together with the entry point once it is chosen
+type program struct {
+	defs  []definition
+	mgr   *typMgr
+	env   *typEnv
+	entry *entryPoint
+}
+
+// Parse and typecheck a source file
+func checkFile(path string) (*program, error) {
+	prog, err := loadProgram(path)
+	if err != nil {
+		return nil, err
+	}
+
+	mgr, e, err := typecheckProgram(prog)
+	if err != nil {
+		return nil, errors.Wrap(err, "Typecheck Error")
+	}
+
+	return &program{prog, mgr, e, nil}, nil
+}
+
+// Lift lambdas and compile every definition, including the arguments of
+// the entry point
+func (p *program) compile() error {
+	p.defs = liftLambdas(p.defs)
+	err := compileProgram(p.defs)
+	if err != nil {
+		return errors.Wrap(err, "Compile Error")
+	}
+
+	return nil
+}
+
+func typecheckProgram(prg []definition) (*typMgr, *typEnv, error) {
 	mgr := newTypMgr()
-	e := &typEnv{
-		make(map[string]typ, 0),
-		nil,
+	e := newTypEnv()
+
+	bindBuiltinTypes(e)
+
+	err := typecheckData(mgr, e, prg)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	intTyp := &typBase{"Int"}
-	binOpTyp := &typArr{
-		intTyp,
-		&typArr{
-			intTyp,
-			intTyp,
-		},
+	bindOperatorTypes(e)
+
+	err = typecheckDefns(mgr, e, prg)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	e.bind("+", binOpTyp)
-	e.bind("-", binOpTyp)
-	e.bind("*", binOpTyp)
-	e.bind("/", binOpTyp)
+	return mgr, e, nil
+}
+
+// Typecheck definitions which extend an already typechecked program
+func typecheckDefinitions(mgr *typMgr, e *typEnv, prg []definition) error {
+	err := typecheckData(mgr, e, prg)
+	if err != nil {
+		return err
+	}
 
+	return typecheckDefns(mgr, e, prg)
+}
+
+func typecheckData(mgr *typMgr, e *typEnv, prg []definition) error {
 	for _, d := range prg {
-		d.typecheckFirst(mgr, e)
+		if _, ok := d.(*definitionData); ok {
+			d.typecheckFirst(mgr, e)
+		}
 	}
 
 	for _, d := range prg {
-		err := d.typecheckSecond(mgr, e)
-		if err != nil {
-			return err
+		if _, ok := d.(*definitionData); ok {
+			err := d.typecheckSecond(mgr, e)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func typecheckDefns(mgr *typMgr, e *typEnv, prg []definition) error {
+	for _, g := range dependencyGroups(programDefns(prg)) {
+		for _, d := range g.members {
+			d.typecheckFirst(mgr, e)
+		}
+
+		for _, d := range g.members {
+			err := d.typecheckSecond(mgr, e)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, d := range g.members {
+			d.generalize(mgr, e)
 		}
 	}
 
@@ -57,74 +143,73 @@ func compileProgram(prog []definition) error {
 		if err != nil {
 			return err
 		}
-
-		defn, ok := d.(*definitionDefn)
-		if !ok {
-			continue
-		}
-
-		for _, i := range defn.instructions {
-			fmt.Printf("%v\n", i)
-		}
-		fmt.Println()
 	}
 
 	return nil
 }
 
-func runProgram(prog []definition) {
-	// Boot G-Machine VM
-	vm := newGVM()
-	//Store every function to heap
+// A global of the compiled program, as it is stored on the VM heap
+type global struct {
+	name  string
+	arity int
+	code  []inst
+}
+
+// Collect the globals of a compiled program. Constructors get code which
+// packs their arguments.
+func programGlobals(prog []definition) []global {
+	globals := make([]global, 0)
 	for _, d := range prog {
 		switch def := d.(type) {
 		case *definitionDefn:
-			vm.addGlobal(def.name, len(def.params), def.instructions)
+			globals = append(globals, global{def.name, len(def.params), def.instructions})
 		case *definitionData:
 			for _, c := range def.constructors {
 				packInsts := make([]inst, 0)
 				packInsts = append(packInsts, instPack{c.tag, len(c.types)})
 				packInsts = append(packInsts, instUpdate{})
 				packInsts = append(packInsts, instUnwind{})
-				vm.addGlobal(c.name, len(c.types), packInsts)
+				globals = append(globals, global{c.name, len(c.types), packInsts})
 			}
 		}
 	}
 
-	vm.pushInst(&instEval{})
-	vm.pushInst(&instPushGlobal{"main"})
-
-	vm.run()
-
-	resultAddr := vm.stack.pop()
-	resultNode, ok := vm.heap[resultAddr]
-	if !ok {
-		log.Fatal("Failed to retrieve resutl")
-	}
+	return globals
+}
 
-	log.Println("The Result is : ", resultNode)
+type runOptions struct {
+	trace    traceLevel
+	overflow overflowMode
+	depth    int
+	interp   interpreter
 }
 
-func main() {
-	if len(os.Args) != 2 {
-		log.Fatalf("Usage %s <file>\n", os.Args[0])
-	}
-	file, err := os.Open(os.Args[1])
-	if err != nil {
-		log.Fatalln(err)
+// Evaluate the entry point of a compiled program and render the result
+func runProgram(p *program, globals []global, opts runOptions) (string, *gVM, error) {
+	// Boot G-Machine VM
+	vm := newGVM()
+	vm.trace = opts.trace
+	vm.overflow = opts.overflow
+	vm.interp = opts.interp
+	//Store every function to heap
+	for _, g := range globals {
+		vm.addGlobal(g.name, g.arity, g.code)
 	}
-	defer file.Close()
 
-	l := newLexer(file)
-	yyParse(l)
-	err = typecheckProgram(l.result)
+	entryAddr, err := vm.apply(p.entry.name, p.entry.args)
 	if err != nil {
-		log.Fatalln("Typecheck Error: ", err)
+		return "", vm, err
 	}
-	err = compileProgram(l.result)
+
+	printer := newResultPrinter(vm, p.mgr, p.env, p.defs, opts.depth)
+	result, err := printer.print(entryAddr, p.entry.typ)
 	if err != nil {
-		log.Fatalln("Compile Error: ", err)
+		return "", vm, err
 	}
 
-	runProgram(l.result)
+	return result, vm, nil
+}
+
+func main() {
+	os.Exit(dispatch(os.Args[1:]))
 }
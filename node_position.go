@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"text/scanner"
+)
+
+// Get Position
+
+func (a astInt) getPosition() scanner.Position {
+	return a.pos
+}
+
+func (a astLID) getPosition() scanner.Position {
+	return a.pos
+}
+
+func (a astUID) getPosition() scanner.Position {
+	return a.pos
+}
+
+func (a astBinOp) getPosition() scanner.Position {
+	return a.pos
+}
+
+func (a astApp) getPosition() scanner.Position {
+	return a.pos
+}
+
+func (a astCase) getPosition() scanner.Position {
+	return a.pos
+}
+
+func (a astLet) getPosition() scanner.Position {
+	return a.pos
+}
+
+func (a astLambda) getPosition() scanner.Position {
+	return a.pos
+}
+
+func (a astIf) getPosition() scanner.Position {
+	return a.pos
+}
+
+func (b branch) getPosition() scanner.Position {
+	return b.pos
+}
+
+func (pv patternVar) getPosition() scanner.Position {
+	return pv.pos
+}
+
+func (pc patternConstr) getPosition() scanner.Position {
+	return pc.pos
+}
+
+func (d definitionDefn) getPosition() scanner.Position {
+	return d.pos
+}
+
+func (d definitionData) getPosition() scanner.Position {
+	return d.pos
+}
+
+// Error located in the source. Only the innermost position is kept.
+type positionError struct {
+	pos scanner.Position
+	err error
+}
+
+func (e positionError) Error() string {
+	return fmt.Sprintf("%v: %v", e.pos, e.err)
+}
+
+func atPosition(pos scanner.Position, err error) error {
+	if _, ok := err.(positionError); ok {
+		return err
+	}
+
+	return positionError{pos, err}
+}
@@ -1,21 +1,9 @@
 package main
 
-func (a *nodeApp) getNodeTag() nodeTagType {
-	return nodeAppTag
-}
-
-func (a *nodeNum) getNodeTag() nodeTagType {
-	return nodeNumTag
-}
-
-func (a *nodeGlobal) getNodeTag() nodeTagType {
+func (a *nodeGlobal) NodeTag() nodeTagType {
 	return nodeGlobalTag
 }
 
-func (a *nodeInd) getNodeTag() nodeTagType {
-	return nodeIndTag
-}
-
-func (a *nodeData) getNodeTag() nodeTagType {
-	return nodeDataTag
+func (a *nodeBigNum) NodeTag() nodeTagType {
+	return nodeBigNumTag
 }
@@ -41,6 +41,18 @@ func (a *astCase) setNodeType(t typ) {
 	a.nodeTyp = t
 }
 
+func (a *astLet) setNodeType(t typ) {
+	a.nodeTyp = t
+}
+
+func (a *astLambda) setNodeType(t typ) {
+	a.nodeTyp = t
+}
+
+func (a *astIf) setNodeType(t typ) {
+	a.nodeTyp = t
+}
+
 // Get NodeType
 func (a astInt) getNodeType() typ {
 	return a.nodeTyp
@@ -81,3 +93,15 @@ func (pc patternConstr) getNodeType() typ {
 func (a astCase) getNodeType() typ {
 	return a.nodeTyp
 }
+
+func (a astLet) getNodeType() typ {
+	return a.nodeTyp
+}
+
+func (a astLambda) getNodeType() typ {
+	return a.nodeTyp
+}
+
+func (a astIf) getNodeType() typ {
+	return a.nodeTyp
+}
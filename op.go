@@ -1,16 +1,30 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
 
-type binOpType = int
+	"github.com/pocket7878/fngo/rt"
+)
+
+type binOpType = rt.Op
 
 const (
-	binOpPlus binOpType = iota
-	binOpMinus
-	binOpTimes
-	binOpDivide
+	binOpPlus   = rt.OpPlus
+	binOpMinus  = rt.OpMinus
+	binOpTimes  = rt.OpTimes
+	binOpDivide = rt.OpDivide
+	binOpEq     = rt.OpEq
+	binOpNe     = rt.OpNe
+	binOpLt     = rt.OpLt
+	binOpLe     = rt.OpLe
+	binOpGt     = rt.OpGt
+	binOpGe     = rt.OpGe
 )
 
+func isComparison(op binOpType) bool {
+	return rt.IsComparison(op)
+}
+
 func opName(op binOpType) (string, error) {
 	switch op {
 	case binOpPlus:
@@ -21,6 +35,18 @@ func opName(op binOpType) (string, error) {
 		return "*", nil
 	case binOpDivide:
 		return "/", nil
+	case binOpEq:
+		return "==", nil
+	case binOpNe:
+		return "/=", nil
+	case binOpLt:
+		return "<", nil
+	case binOpLe:
+		return "<=", nil
+	case binOpGt:
+		return ">", nil
+	case binOpGe:
+		return ">=", nil
 	default:
 		return "??", fmt.Errorf("Unsupported operator: %d", op)
 	}
@@ -36,6 +62,18 @@ func opAction(op binOpType) (string, error) {
 		return "times", nil
 	case binOpDivide:
 		return "divide", nil
+	case binOpEq:
+		return "eq", nil
+	case binOpNe:
+		return "ne", nil
+	case binOpLt:
+		return "lt", nil
+	case binOpLe:
+		return "le", nil
+	case binOpGt:
+		return "gt", nil
+	case binOpGe:
+		return "ge", nil
 	default:
 		return "??", fmt.Errorf("Unsupported operator: %d", op)
 	}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+type (
+	parsedType interface {
+		fmt.Stringer
+		toType(e *typEnv, vars map[string]typ) (typ, error)
+	}
+
+	parsedTypeVar struct {
+		name string
+	}
+
+	parsedTypeApp struct {
+		name string
+		args []parsedType
+	}
+)
+
+func (p parsedTypeVar) toType(e *typEnv, vars map[string]typ) (typ, error) {
+	t, ok := vars[p.name]
+	if !ok {
+		return nil, fmt.Errorf("Unbound type variable: %s", p.name)
+	}
+
+	return t, nil
+}
+
+func (p parsedTypeApp) toType(e *typEnv, vars map[string]typ) (typ, error) {
+	base := e.lookupType(p.name)
+	if base == nil {
+		return nil, fmt.Errorf("Undefined type: %s", p.name)
+	}
+
+	arity := 0
+	if data, ok := base.(*typData); ok {
+		arity = data.arity
+	}
+	if arity != len(p.args) {
+		return nil, fmt.Errorf("Type %s expects %d arguments but got %d", p.name, arity, len(p.args))
+	}
+
+	if len(p.args) == 0 {
+		return base, nil
+	}
+
+	args := make([]typ, len(p.args))
+	for i, a := range p.args {
+		t, err := a.toType(e, vars)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = t
+	}
+
+	return &typApp{base, args}, nil
+}
+
+func (p parsedTypeVar) String() string {
+	return p.name
+}
+
+func (p parsedTypeApp) String() string {
+	if len(p.args) == 0 {
+		return p.name
+	}
+
+	args := make([]string, len(p.args))
+	for i, a := range p.args {
+		args[i] = a.String()
+	}
+
+	return fmt.Sprintf("(%s %s)", p.name, strings.Join(args, " "))
+}
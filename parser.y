@@ -3,7 +3,9 @@
 package main
 
 import (
+    "fmt"
     "io"
+    "strings"
     "text/scanner"
     "unicode"
     "strconv"
@@ -21,11 +23,23 @@ type (
 %token TIMES
 %token MINUS
 %token DIVIDE
+%token EQ
+%token NE
+%token LT
+%token LE
+%token GT
+%token GE
 %token <number> INT
 %token DEFN
 %token DATA
 %token CASE
 %token OF
+%token LET
+%token LETREC
+%token IN
+%token IF
+%token THEN
+%token ELSE
 %token OCURLY
 %token CCURLY
 %token OPAREN
@@ -33,18 +47,26 @@ type (
 %token COMMA
 %token ARROW
 %token EQUAL
+%token BACKSLASH
+%token UNKNOWN
+%token START_PROGRAM
+%token START_EXPRESSION
 %token <lid> LID
 %token <uid> UID
 
-%type <params> lowercaseParams uppercaseParams
+%type <params> lowercaseParams
 %type <definitions> program definitions
 %type <branches> branches
 %type <constructors> constructors
-%type <ast> aAdd aMul case app appBase
+%type <ast> expr let lambda if aCmp aAdd aMul case app appBase
 %type <definition> definition defn data 
 %type <branch> branch
 %type <pattern> pattern
 %type <constructor> constructor
+%type <parsedType> typeAtom typeExpr
+%type <parsedTypes> typeAtoms
+%type <letBindings> letBindings
+%type <letBinding> letBinding
 
 %union {
 	Token item
@@ -57,15 +79,25 @@ type (
     pattern pattern
     constructor constructor
     constructors []constructor
+    parsedType parsedType
+    parsedTypes []parsedType
+    letBinding letBinding
+    letBindings []letBinding
     ast ast
+    pos scanner.Position
     lid string
     uid string
 }
 
-%start program
+%start start
 
 %%
 
+start
+    : START_PROGRAM program
+    | START_EXPRESSION expr { yylex.(*lexer).expr = $2 }
+    ;
+
 program
     : definitions 
     { 
@@ -77,6 +109,8 @@ program
 definitions
     : definitions definition { $$ = $1; $$ = append($$, $2); }
     | definition { $$ = make([]definition, 0); $$ = append($$, $1); }
+    | definitions error { $$ = $1; }
+    | error { $$ = make([]definition, 0); }
     ;
 
 definition
@@ -85,8 +119,8 @@ definition
     ;
 
 defn
-    : DEFN LID lowercaseParams EQUAL OCURLY aAdd CCURLY
-        { $$ = newDefinitionDefn($2, $3, $6) }
+    : DEFN LID lowercaseParams EQUAL OCURLY expr CCURLY
+        { $$ = newDefinitionDefn($2, $3, $6, $<pos>1) }
     ;
 
 lowercaseParams 
@@ -94,39 +128,75 @@ lowercaseParams
     | lowercaseParams LID { $$ = $1; $$ = append($$, $2); }
     ;
 
-uppercaseParams
-    : { $$ = make([]string, 0); }
-    | uppercaseParams UID { $$ = $1; $$ = append($$, $2); }
+expr
+    : aCmp { $$ = $1; }
+    | let { $$ = $1; }
+    | lambda { $$ = $1; }
+    | if { $$ = $1; }
+    ;
+
+if
+    : IF expr THEN expr ELSE expr
+        { $$ = &astIf{$2, $4, $6, nil, $<pos>1}; }
+    ;
+
+lambda
+    : BACKSLASH LID lowercaseParams ARROW expr
+        { $$ = &astLambda{append([]string{$2}, $3...), $5, nil, $<pos>1}; }
+    ;
+
+let
+    : LET letBindings IN expr { $$ = &astLet{false, $2, $4, nil, $<pos>1}; }
+    | LETREC letBindings IN expr { $$ = &astLet{true, $2, $4, nil, $<pos>1}; }
+    ;
+
+letBindings
+    : letBindings COMMA letBinding { $$ = $1; $$ = append($$, $3); }
+    | letBinding { $$ = make([]letBinding, 0); $$ = append($$, $1); }
+    ;
+
+letBinding
+    : LID EQUAL expr { $$ = letBinding{$1, $3, $<pos>1}; }
+    ;
+
+aCmp
+    : aAdd EQ aAdd { $$ = &astBinOp{binOpEq, $1, $3, nil, $<pos>2}; }
+    | aAdd NE aAdd { $$ = &astBinOp{binOpNe, $1, $3, nil, $<pos>2}; }
+    | aAdd LT aAdd { $$ = &astBinOp{binOpLt, $1, $3, nil, $<pos>2}; }
+    | aAdd LE aAdd { $$ = &astBinOp{binOpLe, $1, $3, nil, $<pos>2}; }
+    | aAdd GT aAdd { $$ = &astBinOp{binOpGt, $1, $3, nil, $<pos>2}; }
+    | aAdd GE aAdd { $$ = &astBinOp{binOpGe, $1, $3, nil, $<pos>2}; }
+    | aAdd { $$ = $1; }
     ;
 
 aAdd
-    : aAdd PLUS aMul { $$ = &astBinOp{binOpPlus, $1, $3, nil}; }
-    | aAdd MINUS aMul { $$ = &astBinOp{binOpMinus, $1, $3, nil}; }
+    : aAdd PLUS aMul { $$ = &astBinOp{binOpPlus, $1, $3, nil, $<pos>2}; }
+    | aAdd MINUS aMul { $$ = &astBinOp{binOpMinus, $1, $3, nil, $<pos>2}; }
     | aMul { $$ = $1; }
     ;
 
 aMul
-    : aAdd TIMES aMul { $$ = &astBinOp{binOpTimes, $1, $3, nil}; }
-    | aAdd DIVIDE aMul { $$ = &astBinOp{binOpDivide, $1, $3, nil}; }
+    : aMul TIMES app { $$ = &astBinOp{binOpTimes, $1, $3, nil, $<pos>2}; }
+    | aMul DIVIDE app { $$ = &astBinOp{binOpDivide, $1, $3, nil, $<pos>2}; }
     | app { $$ = $1; }
     ;
 
 app
-    : app appBase { $$ = &astApp{$1, $2, nil}; }
+    : app appBase { $$ = &astApp{$1, $2, nil, $1.getPosition()}; }
     | appBase { $$ = $1; }
     ;
 
 appBase
-    : INT { $$ = &astInt{$1, nil}; }
-    | LID { $$ = &astLID{$1, nil}; }
-    | UID { $$ = &astUID{$1, nil}; }
-    | OPAREN aAdd CPAREN { $$ = $2; }
+    : INT { $$ = &astInt{$1, nil, $<pos>1}; }
+    | LID { $$ = &astLID{$1, nil, $<pos>1}; }
+    | UID { $$ = &astUID{$1, nil, $<pos>1}; }
+    | OPAREN expr CPAREN { $$ = $2; }
     | case { $$ = $1; }
     ;
 
 case
-    : CASE aAdd OF OCURLY branches CCURLY 
-        { $$ = &astCase{$2, $5, nil}; }
+    : CASE expr OF OCURLY branches CCURLY 
+        { $$ = &astCase{$2, $5, nil, $<pos>1}; }
     ;
 
 branches
@@ -135,19 +205,19 @@ branches
     ;
 
 branch
-    : pattern ARROW OCURLY aAdd CCURLY
-        { $$ = branch{$1, $4, nil}; }
+    : pattern ARROW OCURLY expr CCURLY
+        { $$ = branch{$1, $4, nil, $1.getPosition()}; }
     ;
 
 pattern
-    : LID { $$ = &patternVar{$1, nil}; }
+    : LID { $$ = patternVar{$1, nil, $<pos>1}; }
     | UID lowercaseParams
-        { $$ = patternConstr{$1, $2, nil}; }
+        { $$ = patternConstr{$1, $2, nil, $<pos>1}; }
     ;
 
 data
-    : DATA UID EQUAL OCURLY constructors CCURLY
-        { $$ = &definitionData{$2, $5, nil}; }
+    : DATA UID lowercaseParams EQUAL OCURLY constructors CCURLY
+        { $$ = &definitionData{$2, $3, $6, nil, $<pos>1}; }
     ;
 
 constructors
@@ -157,71 +227,242 @@ constructors
     ;
 
 constructor
-    : UID uppercaseParams
-        { $$ = constructor{$1, $2, -1, nil}; }
+    : UID typeAtoms
+        { $$ = constructor{$1, $2, -1, nil, $<pos>1}; }
+    ;
+
+typeAtoms
+    : { $$ = make([]parsedType, 0); }
+    | typeAtoms typeAtom { $$ = $1; $$ = append($$, $2); }
+    ;
+
+typeAtom
+    : LID { $$ = &parsedTypeVar{$1}; }
+    | UID { $$ = &parsedTypeApp{$1, make([]parsedType, 0)}; }
+    | OPAREN typeExpr CPAREN { $$ = $2; }
+    ;
+
+typeExpr
+    : UID typeAtoms { $$ = &parsedTypeApp{$1, $2}; }
+    | LID { $$ = &parsedTypeVar{$1}; }
+    | OPAREN typeExpr CPAREN { $$ = $2; }
     ;
 
 %%
 
 var simpleTokenTypeTable = map[string]int{
-	"+":    PLUS,
-	"*":    TIMES,
-	"/":    DIVIDE,
-	"defn": DEFN,
-	"data": DATA,
-	"case": CASE,
-	"of":   OF,
-	"{":    OCURLY,
-	"}":    CCURLY,
-	"(":    OPAREN,
-	")":    CPAREN,
-	",":    COMMA,
-	"=":    EQUAL,
+	"+":      PLUS,
+	"-":      MINUS,
+	"<":      LT,
+	">":      GT,
+	"*":      TIMES,
+	"/":      DIVIDE,
+	"defn":   DEFN,
+	"data":   DATA,
+	"case":   CASE,
+	"of":     OF,
+	"let":    LET,
+	"letrec": LETREC,
+	"in":     IN,
+	"if":     IF,
+	"then":   THEN,
+	"else":   ELSE,
+	"{":      OCURLY,
+	"}":      CCURLY,
+	"(":      OPAREN,
+	")":      CPAREN,
+	",":      COMMA,
+	"=":      EQUAL,
+	"\\":     BACKSLASH,
+}
+
+
+// Tokens made of a simple token followed by one more character
+var twoCharTokenTypeTable = map[string]int{
+	"->": ARROW,
+	"==": EQ,
+	"/=": NE,
+	"<=": LE,
+	">=": GE,
 }
 
+// Display names of tokens in syntax error messages
+var tokenDisplayNames = map[string]string{
+	"$end":      "end of file",
+	"UNKNOWN":   "unknown token",
+	"PLUS":      "+",
+	"TIMES":     "*",
+	"MINUS":     "-",
+	"DIVIDE":    "/",
+	"EQ":        "==",
+	"NE":        "/=",
+	"LT":        "<",
+	"LE":        "<=",
+	"GT":        ">",
+	"GE":        ">=",
+	"INT":       "integer",
+	"DEFN":      "defn",
+	"DATA":      "data",
+	"CASE":      "case",
+	"OF":        "of",
+	"LET":       "let",
+	"LETREC":    "letrec",
+	"IN":        "in",
+	"IF":        "if",
+	"THEN":      "then",
+	"ELSE":      "else",
+	"OCURLY":    "{",
+	"CCURLY":    "}",
+	"OPAREN":    "(",
+	"CPAREN":    ")",
+	"COMMA":     ",",
+	"ARROW":     "->",
+	"EQUAL":     "=",
+	"BACKSLASH": "\\",
+	"LID":       "identifier",
+	"UID":       "capitalized name",
+}
+
+type (
+	syntaxError struct {
+		pos        scanner.Position
+		unexpected string
+		expected   []string
+		msg        string
+	}
+
+	syntaxErrors []syntaxError
+)
+
+func init() {
+	yyErrorVerbose = true
+}
+
+func (e syntaxError) Error() string {
+	if e.unexpected == "" {
+		return fmt.Sprintf("%v: %s", e.pos, e.msg)
+	}
+
+	result := fmt.Sprintf("%v: syntax error: unexpected %s", e.pos, e.unexpected)
+	if len(e.expected) > 0 {
+		result += fmt.Sprintf(", expecting %s", strings.Join(e.expected, " or "))
+	}
+
+	return result
+}
+
+func (es syntaxErrors) Error() string {
+	msgs := make([]string, len(es))
+	for i, e := range es {
+		msgs[i] = e.Error()
+	}
+
+	return strings.Join(msgs, "\n")
+}
+
+func tokenDisplayName(name string) string {
+	if it, ok := tokenDisplayNames[name]; ok {
+		return it
+	}
+
+	return name
+}
 
 type lexer struct {
 	scanner scanner.Scanner
+    start int
     result []definition
+    expr ast
+    errors syntaxErrors
+    lastPos scanner.Position
+    lastText string
 }
 
-func newLexer(reader io.Reader) *lexer {
-	var s scanner.Scanner
-	s.Init(reader)
-	return &lexer{
-		s,
+// The start token selects what the parser accepts, a whole program or a
+// single expression
+func newLexer(name string, reader io.Reader, start int) *lexer {
+	l := &lexer{
+		scanner.Scanner{},
+        start,
         make([]definition, 0),
+        nil,
+        make(syntaxErrors, 0),
+        scanner.Position{},
+        "",
 	}
+	l.scanner.Init(reader)
+	l.scanner.Filename = name
+	l.scanner.Error = func(s *scanner.Scanner, msg string) {
+		l.errors = append(l.errors, syntaxError{s.Position, "", nil, msg})
+	}
+
+	return l
+}
+
+// Parse a whole program, collecting every syntax error
+func parseProgram(name string, reader io.Reader) ([]definition, error) {
+	l := newLexer(name, reader, START_PROGRAM)
+	if yyParse(l) != 0 || len(l.errors) > 0 {
+		return nil, l.errors
+	}
+
+	return l.result, nil
+}
+
+// Parse a single expression
+func parseExpression(name string, reader io.Reader) (ast, error) {
+	l := newLexer(name, reader, START_EXPRESSION)
+	if yyParse(l) != 0 || len(l.errors) > 0 {
+		return nil, l.errors
+	}
+
+	return l.expr, nil
 }
 
 func (l *lexer) Lex(lval *yySymType) int {
+    if l.start != 0 {
+        start := l.start
+        l.start = 0
+
+        return start
+    }
+
     tok := l.scanner.Scan()
+    lval.pos = l.scanner.Position
+    l.lastPos = l.scanner.Position
     if tok == scanner.EOF {
+        // Position is not valid at the end of an empty file, so report
+        // where the scanner stopped
+        lval.pos = l.scanner.Pos()
+        l.lastPos = lval.pos
+        l.lastText = ""
         return 0
     }
 
     tokenText := l.scanner.TokenText()
+    l.lastText = tokenText
     if tok == scanner.Int {
-        lval.number, _ = strconv.Atoi(tokenText)
+        var err error
+        lval.number, err = strconv.Atoi(tokenText)
+        if numErr, ok := err.(*strconv.NumError); ok && numErr.Err == strconv.ErrRange {
+            l.errors = append(l.errors, syntaxError{lval.pos, "", nil, fmt.Sprintf("integer literal out of range: %s", tokenText)})
+        } else if err != nil {
+            l.errors = append(l.errors, syntaxError{lval.pos, "", nil, fmt.Sprintf("invalid integer literal: %s", tokenText)})
+        }
         return INT
     }
 
-    it, ok := simpleTokenTypeTable[tokenText]
-    if ok {
+    twoCharText := tokenText + string(l.scanner.Peek())
+    if it, ok := twoCharTokenTypeTable[twoCharText]; ok {
+        l.scanner.Next()
+        l.lastText = twoCharText
+
         return it
     }
 
-    if tokenText == "-" {
-        nextToken := l.scanner.Peek()
-        if nextToken == scanner.EOF {
-            return MINUS
-        } else if nextToken == '>' {
-            l.scanner.Scan()
-
-            return ARROW
-        } else {
-            return MINUS
-        }
+    it, ok := simpleTokenTypeTable[tokenText]
+    if ok {
+        return it
     }
 
     if unicode.IsLower(rune(tokenText[0])) {
@@ -233,11 +474,23 @@ func (l *lexer) Lex(lval *yySymType) int {
 
         return UID
     } else {
-
-        return 0
+        // Let the parser report the unknown token
+        return UNKNOWN
     }
 }
 
 func (l *lexer) Error(e string) {
-    panic(e)
+    unexpected := tokenDisplayName("$end")
+    if l.lastText != "" {
+        unexpected = strconv.Quote(l.lastText)
+    }
+
+    expected := make([]string, 0)
+    if i := strings.Index(e, ", expecting "); i >= 0 {
+        for _, name := range strings.Split(e[i+len(", expecting "):], " or ") {
+            expected = append(expected, tokenDisplayName(name))
+        }
+    }
+
+    l.errors = append(l.errors, syntaxError{l.lastPos, unexpected, expected, e})
 }
\ No newline at end of file
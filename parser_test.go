@@ -0,0 +1,30 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseErrors(t *testing.T) {
+	cases := []struct {
+		src string
+		err string
+	}{
+		{"defn main = { 99999999999999999999 + 1 }", "test.fn:1:15: integer literal out of range: 99999999999999999999"},
+		{"defn main = { 0x10 }", "test.fn:1:15: invalid integer literal: 0x10"},
+		{"data = { Nil }", `test.fn:1:6: syntax error: unexpected "=", expecting capitalized name`},
+		{"", "test.fn:1:1: syntax error: unexpected end of file, expecting defn or data"},
+		{"defn main = {\n", "test.fn:2:1: syntax error: unexpected end of file"},
+	}
+
+	for _, c := range cases {
+		_, err := parseProgram("test.fn", strings.NewReader(c.src))
+		if err == nil {
+			t.Errorf("%q: expected an error", c.src)
+			continue
+		}
+		if err.Error() != c.err {
+			t.Errorf("%q: got error %q, want %q", c.src, err, c.err)
+		}
+	}
+}
@@ -12,10 +12,11 @@ func (pv patternVar) match(t typ, mgr *typMgr, e *typEnv) error {
 }
 
 func (pc patternConstr) match(t typ, mgr *typMgr, e *typEnv) error {
-	constrTyp := e.lookup(pc.constr)
-	if constrTyp == nil {
+	constrScheme := e.lookup(pc.constr)
+	if constrScheme == nil {
 		return fmt.Errorf("Failed to lookp constructor type: %s", pc.constr)
 	}
+	constrTyp := constrScheme.instantiate(mgr)
 
 	for _, param := range pc.params {
 		arr, ok := constrTyp.(*typArr)
@@ -27,16 +28,17 @@ func (pc patternConstr) match(t typ, mgr *typMgr, e *typEnv) error {
 		constrTyp = arr.right
 	}
 
-	err := mgr.unify(t, constrTyp)
-	if err != nil {
-		return err
+	if _, ok := constrTyp.(*typArr); ok {
+		return fmt.Errorf("Too few parameters for constructor %s: %v", pc.constr, constrTyp)
 	}
 
-	_, ok := constrTyp.(*typBase)
+	return mgr.unify(t, constrTyp)
+}
 
-	if !ok {
-		return fmt.Errorf("Failed to unify constructor type: %v", constrTyp)
-	}
+func (pv patternVar) variables() []string {
+	return []string{pv.variable}
+}
 
-	return nil
+func (pc patternConstr) variables() []string {
+	return pc.params
 }
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/pocket7878/fngo/rt"
+)
+
+// A lazy interpreter walking the typechecked ast, which shares nothing with
+// the G-machine compiler and so serves as its reference. Arguments and let
+// bindings become thunks, which are evaluated at most once.
+type (
+	refValue interface{}
+
+	refInt struct {
+		value int
+	}
+
+	// Integer which does not fit in refInt, only made in overflowBignum mode
+	refBig struct {
+		value *big.Int
+	}
+
+	refData struct {
+		name   string
+		tag    int
+		fields []*refThunk
+	}
+
+	// Function applied to fewer arguments than its arity
+	refFunc struct {
+		arity int
+		args  []*refThunk
+		call  func(args []*refThunk) (refValue, error)
+	}
+
+	refThunk struct {
+		expr  ast
+		env   *refEnv
+		value refValue
+		// Set while the thunk is evaluated, so a value depending on itself
+		// is an error instead of a stack overflow. The error has no
+		// position, since the G-machine finds it as a hole in the heap.
+		forcing bool
+	}
+
+	refEnv struct {
+		name   string
+		thunk  *refThunk
+		parent *refEnv
+	}
+
+	refInterp struct {
+		globals  map[string]*refThunk
+		maxDepth int
+		overflow overflowMode
+	}
+)
+
+// Evaluate the entry point of a typechecked program and render the result
+// the way resultPrinter does. Must run before the program is compiled, since
+// lambda lifting rewrites the ast.
+func runReference(p *program, maxDepth int, overflow overflowMode) (string, error) {
+	r := &refInterp{make(map[string]*refThunk), maxDepth, overflow}
+	for _, d := range p.defs {
+		switch def := d.(type) {
+		case *definitionDefn:
+			r.globals[def.name] = r.defn(def)
+		case *definitionData:
+			for _, c := range def.constructors {
+				r.globals[c.name] = refConstructor(c)
+			}
+		}
+	}
+
+	v, err := r.global(p.entry.name)
+	if err != nil {
+		return "", err
+	}
+	for _, a := range p.entry.args {
+		arg, ok := r.globals[a]
+		if !ok {
+			return "", fmt.Errorf("Undefined function: %s", a)
+		}
+		v, err = r.apply(v, arg)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return rt.Print(refTerm{r, evaluated(v)}, r.maxDepth)
+}
+
+func evaluated(v refValue) *refThunk {
+	return &refThunk{nil, nil, v, false}
+}
+
+// A global without parameters is a thunk shared by all its uses, like the
+// updated node of the G-machine
+func (r *refInterp) defn(d *definitionDefn) *refThunk {
+	if len(d.params) == 0 {
+		return &refThunk{d.body, nil, nil, false}
+	}
+
+	return evaluated(r.function(d.params, d.body, nil))
+}
+
+// The first parameter is bound innermost, as in the compEnv of a definition
+func (r *refInterp) function(params []string, body ast, env *refEnv) *refFunc {
+	return &refFunc{len(params), nil, func(args []*refThunk) (refValue, error) {
+		e := env
+		for i := len(params) - 1; i >= 0; i-- {
+			e = &refEnv{params[i], args[i], e}
+		}
+
+		return r.eval(body, e)
+	}}
+}
+
+func refConstructor(c constructor) *refThunk {
+	if len(c.types) == 0 {
+		return evaluated(&refData{c.name, c.tag, nil})
+	}
+
+	return evaluated(&refFunc{len(c.types), nil, func(args []*refThunk) (refValue, error) {
+		return &refData{c.name, c.tag, args}, nil
+	}})
+}
+
+func refBool(b bool) *refData {
+	if b {
+		return &refData{"True", boolTrueTag, nil}
+	}
+
+	return &refData{"False", boolFalseTag, nil}
+}
+
+func (r *refInterp) global(name string) (refValue, error) {
+	t, ok := r.globals[name]
+	if !ok {
+		return nil, fmt.Errorf("Undefined function: %s", name)
+	}
+
+	return r.force(t)
+}
+
+func (r *refInterp) force(t *refThunk) (refValue, error) {
+	if t.value != nil {
+		return t.value, nil
+	}
+	if t.forcing {
+		return nil, fmt.Errorf("Value depends on itself")
+	}
+
+	t.forcing = true
+	v, err := r.eval(t.expr, t.env)
+	t.forcing = false
+	if err != nil {
+		return nil, err
+	}
+	t.value = v
+	t.expr = nil
+	t.env = nil
+
+	return v, nil
+}
+
+func (r *refInterp) apply(f refValue, arg *refThunk) (refValue, error) {
+	fn, ok := f.(*refFunc)
+	if !ok {
+		return nil, fmt.Errorf("Not a function: %v", f)
+	}
+
+	args := make([]*refThunk, len(fn.args), len(fn.args)+1)
+	copy(args, fn.args)
+	args = append(args, arg)
+	if len(args) < fn.arity {
+		return &refFunc{fn.arity, args, fn.call}, nil
+	}
+
+	return fn.call(args)
+}
+
+func (e *refEnv) lookup(name string) (*refThunk, bool) {
+	for ; e != nil; e = e.parent {
+		if e.name == name {
+			return e.thunk, true
+		}
+	}
+
+	return nil, false
+}
+
+// Evaluate expr to weak head normal form
+func (r *refInterp) eval(expr ast, env *refEnv) (refValue, error) {
+	switch a := expr.(type) {
+	case *astInt:
+		return &refInt{a.value}, nil
+	case *astLID:
+		if t, ok := env.lookup(a.ID); ok {
+			return r.force(t)
+		}
+		return r.global(a.ID)
+	case *astUID:
+		return r.global(a.ID)
+	case *astBinOp:
+		return r.binOp(a, env)
+	case *astApp:
+		f, err := r.eval(a.left, env)
+		if err != nil {
+			return nil, err
+		}
+		return r.apply(f, &refThunk{a.right, env, nil, false})
+	case *astCase:
+		return r.evalCase(a, env)
+	case *astLet:
+		return r.eval(a.body, r.bind(a, env))
+	case *astLambda:
+		return r.function(a.params, a.body, env), nil
+	case *astIf:
+		cond, err := r.eval(a.cond, env)
+		if err != nil {
+			return nil, err
+		}
+		d, ok := cond.(*refData)
+		if !ok {
+			return nil, atPosition(a.pos, fmt.Errorf("Not a Bool: %v", cond))
+		}
+		if d.tag == boolTrueTag {
+			return r.eval(a.thenExpr, env)
+		}
+		return r.eval(a.elseExpr, env)
+	default:
+		return nil, fmt.Errorf("Unknown expression: %v", expr)
+	}
+}
+
+// Operands are evaluated right to left, as the compiled code does, so the
+// same error is reported first
+func (r *refInterp) binOp(a *astBinOp, env *refEnv) (refValue, error) {
+	rv, err := r.eval(a.right, env)
+	if err != nil {
+		return nil, err
+	}
+	lv, err := r.eval(a.left, env)
+	if err != nil {
+		return nil, err
+	}
+
+	ln, lok := lv.(*refInt)
+	rn, rok := rv.(*refInt)
+	if !lok || !rok || r.overflow != overflowWrap {
+		v, err := r.bigBinOp(a.op, lv, rv)
+		if err != nil {
+			return nil, atPosition(a.pos, err)
+		}
+		return v, nil
+	}
+	l, n := ln.value, rn.value
+
+	switch a.op {
+	case binOpPlus:
+		return &refInt{l + n}, nil
+	case binOpMinus:
+		return &refInt{l - n}, nil
+	case binOpTimes:
+		return &refInt{l * n}, nil
+	case binOpDivide:
+		if n == 0 {
+			return nil, atPosition(a.pos, fmt.Errorf("Division by zero"))
+		}
+		return &refInt{l / n}, nil
+	case binOpEq:
+		return refBool(l == n), nil
+	case binOpNe:
+		return refBool(l != n), nil
+	case binOpLt:
+		return refBool(l < n), nil
+	case binOpLe:
+		return refBool(l <= n), nil
+	case binOpGt:
+		return refBool(l > n), nil
+	case binOpGe:
+		return refBool(l >= n), nil
+	default:
+		return nil, atPosition(a.pos, fmt.Errorf("Unsupported BinOp: %d", a.op))
+	}
+}
+
+// Arithmetic checked for overflow, which is an error unless the result may
+// be a refBig
+func (r *refInterp) bigBinOp(op binOpType, lv refValue, rv refValue) (refValue, error) {
+	l, lok := refBigValue(lv)
+	n, rok := refBigValue(rv)
+	if !lok || !rok {
+		return nil, fmt.Errorf("Not a number: %v %v", lv, rv)
+	}
+
+	result := new(big.Int)
+	switch op {
+	case binOpPlus:
+		result.Add(l, n)
+	case binOpMinus:
+		result.Sub(l, n)
+	case binOpTimes:
+		result.Mul(l, n)
+	case binOpDivide:
+		if n.Sign() == 0 {
+			return nil, fmt.Errorf("Division by zero")
+		}
+		result.Quo(l, n)
+	case binOpEq:
+		return refBool(l.Cmp(n) == 0), nil
+	case binOpNe:
+		return refBool(l.Cmp(n) != 0), nil
+	case binOpLt:
+		return refBool(l.Cmp(n) < 0), nil
+	case binOpLe:
+		return refBool(l.Cmp(n) <= 0), nil
+	case binOpGt:
+		return refBool(l.Cmp(n) > 0), nil
+	case binOpGe:
+		return refBool(l.Cmp(n) >= 0), nil
+	default:
+		return nil, fmt.Errorf("Unsupported BinOp: %d", op)
+	}
+
+	if result.IsInt64() {
+		return &refInt{int(result.Int64())}, nil
+	}
+	if r.overflow == overflowBignum {
+		return &refBig{result}, nil
+	}
+
+	o, _ := opName(op)
+	return nil, fmt.Errorf("Integer overflow: %v %s %v", l, o, n)
+}
+
+func refBigValue(v refValue) (*big.Int, bool) {
+	switch v := v.(type) {
+	case *refInt:
+		return big.NewInt(int64(v.value)), true
+	case *refBig:
+		return v.value, true
+	default:
+		return nil, false
+	}
+}
+
+// The first branch whose pattern matches is taken
+func (r *refInterp) evalCase(a *astCase, env *refEnv) (refValue, error) {
+	v, err := r.eval(a.of, env)
+	if err != nil {
+		return nil, err
+	}
+	d, ok := v.(*refData)
+	if !ok {
+		return nil, atPosition(a.pos, fmt.Errorf("Expected data, got %v", v))
+	}
+
+	for _, b := range a.branches {
+		switch pat := b.pat.(type) {
+		case patternVar:
+			return r.eval(b.expr, &refEnv{pat.variable, evaluated(d), env})
+		case patternConstr:
+			if pat.constr != d.name {
+				continue
+			}
+			if len(pat.params) != len(d.fields) {
+				return nil, atPosition(pat.pos, fmt.Errorf("Wrong number of fields for %s", d.name))
+			}
+			e := env
+			for i := len(pat.params) - 1; i >= 0; i-- {
+				e = &refEnv{pat.params[i], d.fields[i], e}
+			}
+			return r.eval(b.expr, e)
+		}
+	}
+
+	return nil, atPosition(a.pos, fmt.Errorf("No branch for tag %d", d.tag))
+}
+
+// Bindings of a let see the ones before them, and those of a letrec see all
+// of them
+func (r *refInterp) bind(a *astLet, env *refEnv) *refEnv {
+	if !a.rec {
+		for _, b := range a.bindings {
+			env = &refEnv{b.name, &refThunk{b.expr, env, nil, false}, env}
+		}
+		return env
+	}
+
+	thunks := make([]*refThunk, 0)
+	for _, b := range a.bindings {
+		t := &refThunk{b.expr, nil, nil, false}
+		thunks = append(thunks, t)
+		env = &refEnv{b.name, t, env}
+	}
+	for _, t := range thunks {
+		t.env = env
+	}
+
+	return env
+}
+
+// A thunk forced as the printer reaches it
+type refTerm struct {
+	r *refInterp
+	t *refThunk
+}
+
+func (t refTerm) Eval() (rt.Shape, error) {
+	v, err := t.r.force(t.t)
+	if err != nil {
+		return rt.Shape{}, err
+	}
+
+	switch v := v.(type) {
+	case *refInt:
+		return rt.Shape{Kind: rt.ShapeNumber, Number: fmt.Sprintf("%d", v.value)}, nil
+	case *refBig:
+		return rt.Shape{Kind: rt.ShapeNumber, Number: v.value.String()}, nil
+	case *refData:
+		fields := make([]rt.Term, len(v.fields))
+		for i, f := range v.fields {
+			fields[i] = refTerm{t.r, f}
+		}
+		return rt.Shape{Kind: rt.ShapeData, Name: v.name, Fields: fields}, nil
+	default:
+		return rt.Shape{Kind: rt.ShapeFunction}, nil
+	}
+}
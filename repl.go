@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const replHelp = `Enter a definition to add it, or an expression to evaluate it.
+Commands:
+  :type <expr>   show the type of an expression
+  :gcode <name>  show the compiled instructions of a global
+  :load <file>   add the definitions of a file
+  :help          show this message
+  :quit          leave the REPL`
+
+// Keeps the typechecked program and the VM between inputs, so definitions
+// can be added one at a time and expressions evaluated against them
+type repl struct {
+	prog  *program
+	vm    *gVM
+	opts  runOptions
+	count int
+	out   io.Writer
+}
+
+func newRepl(opts runOptions, out io.Writer) (*repl, error) {
+	defs := builtinDefinitions()
+	mgr, e, err := typecheckProgram(defs)
+	if err != nil {
+		return nil, errors.Wrap(err, "Typecheck Error")
+	}
+
+	p := &program{defs, mgr, e, nil}
+	err = p.compile()
+	if err != nil {
+		return nil, err
+	}
+
+	vm := newGVM()
+	vm.trace = opts.trace
+	vm.overflow = opts.overflow
+	vm.interp = opts.interp
+	for _, g := range programGlobals(p.defs) {
+		vm.addGlobal(g.name, g.arity, g.code)
+	}
+
+	return &repl{p, vm, opts, 0, out}, nil
+}
+
+// Names can not be redefined, since compiled code refers to globals by name
+func (r *repl) checkNames(defs []definition) error {
+	for _, d := range defs {
+		switch def := d.(type) {
+		case *definitionDefn:
+			if r.prog.env.lookup(def.name) != nil {
+				return atPosition(def.pos, fmt.Errorf("%s is already defined", def.name))
+			}
+		case *definitionData:
+			if r.prog.env.lookupType(def.name) != nil {
+				return atPosition(def.pos, fmt.Errorf("Type %s is already defined", def.name))
+			}
+			for _, c := range def.constructors {
+				if r.prog.env.lookup(c.name) != nil {
+					return atPosition(c.pos, fmt.Errorf("%s is already defined", c.name))
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// Typecheck and compile definitions and add them to the VM. Nothing is kept
+// when one of them is rejected.
+func (r *repl) define(defs []definition) error {
+	err := r.checkNames(defs)
+	if err != nil {
+		return err
+	}
+
+	scope := r.prog.env.scope()
+	err = typecheckDefinitions(r.prog.mgr, scope, defs)
+	if err != nil {
+		return errors.Wrap(err, "Typecheck Error")
+	}
+
+	defs = liftLambdas(defs)
+	err = compileProgram(defs)
+	if err != nil {
+		return errors.Wrap(err, "Compile Error")
+	}
+
+	scope.commit()
+	r.prog.defs = append(r.prog.defs, defs...)
+	for _, g := range programGlobals(defs) {
+		r.vm.addGlobal(g.name, g.arity, g.code)
+	}
+
+	return nil
+}
+
+// An expression is defined as a global without parameters, so it is
+// evaluated once and its value is shared like any other global. It is
+// forgotten once printed, see forget.
+func (r *repl) parseInput(text string) (*definitionDefn, error) {
+	r.count++
+	body, err := parseExpression(fmt.Sprintf("<input %d>", r.count), strings.NewReader(text))
+	if err != nil {
+		return nil, err
+	}
+
+	name := fmt.Sprintf("$it%d", r.count)
+	return newDefinitionDefn(name, make([]string, 0), body, body.getPosition()), nil
+}
+
+func (r *repl) evaluate(text string) (string, error) {
+	d, err := r.parseInput(text)
+	if err != nil {
+		return "", err
+	}
+	defer r.forget(d.name, len(r.prog.defs), r.vm.mark())
+	err = r.define([]definition{d})
+	if err != nil {
+		return "", err
+	}
+
+	t := r.prog.env.lookup(d.name).instantiate(r.prog.mgr)
+	var v *typVar
+	if _, ok := r.prog.mgr.resolve(t, &v).(*typArr); ok {
+		return fmt.Sprintf("<function> : %s", t.typString(r.prog.mgr)), nil
+	}
+
+	printer := newResultPrinter(r.vm, r.prog.mgr, r.prog.env, r.prog.defs, r.opts.depth)
+	result, err := printer.print(r.vm.globalMap[d.name], t)
+	if err != nil {
+		r.vm.reset()
+		return "", errors.Wrap(err, "Runtime Error")
+	}
+
+	return result, nil
+}
+
+// Drop the global of an evaluated expression, the lambdas lifted out of it
+// and their code. Globals are roots of the collector, so keeping them would
+// keep every result alive.
+func (r *repl) forget(name string, defs int, mark denseMark) {
+	r.prog.defs = r.prog.defs[:defs]
+	r.vm.truncate(mark)
+	delete(r.prog.env.names, name)
+}
+
+// The expression is typechecked in a scope which is thrown away
+func (r *repl) typeOf(text string) (string, error) {
+	d, err := r.parseInput(text)
+	if err != nil {
+		return "", err
+	}
+
+	scope := r.prog.env.scope()
+	err = typecheckDefinitions(r.prog.mgr, scope, []definition{d})
+	if err != nil {
+		return "", errors.Wrap(err, "Typecheck Error")
+	}
+
+	return scope.lookup(d.name).typString(r.prog.mgr), nil
+}
+
+// Code of a global together with the lambdas lifted out of it
+func (r *repl) gcode(name string) error {
+	globals := make([]global, 0)
+	for _, g := range programGlobals(r.prog.defs) {
+		if g.name == name || strings.HasPrefix(g.name, name+"$") {
+			globals = append(globals, g)
+		}
+	}
+	if len(globals) == 0 {
+		return fmt.Errorf("Undefined global: %s", name)
+	}
+
+	return writeGCode(r.out, globals)
+}
+
+func (r *repl) load(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	defs, err := parseProgram(path, file)
+	if err != nil {
+		return err
+	}
+
+	return r.define(defs)
+}
+
+// Handle one complete input. Returns false when the REPL should stop.
+func (r *repl) handle(input string) (bool, error) {
+	input = strings.TrimSpace(input)
+	if input == "" {
+		return true, nil
+	}
+
+	if strings.HasPrefix(input, ":") {
+		command := input
+		arg := ""
+		if i := strings.IndexAny(input, " \t\n"); i >= 0 {
+			command = input[:i]
+			arg = strings.TrimSpace(input[i:])
+		}
+
+		switch command {
+		case ":quit", ":q":
+			return false, nil
+		case ":help", ":h":
+			fmt.Fprintln(r.out, replHelp)
+			return true, nil
+		case ":type", ":t":
+			t, err := r.typeOf(arg)
+			if err != nil {
+				return true, err
+			}
+			fmt.Fprintf(r.out, "%s : %s\n", arg, t)
+			return true, nil
+		case ":gcode":
+			return true, r.gcode(arg)
+		case ":load":
+			return true, r.load(arg)
+		default:
+			return true, fmt.Errorf("Unknown command: %s (try :help)", command)
+		}
+	}
+
+	if keyword := strings.Fields(input)[0]; keyword == "defn" || keyword == "data" {
+		r.count++
+		defs, err := parseProgram(fmt.Sprintf("<input %d>", r.count), strings.NewReader(input))
+		if err != nil {
+			return true, err
+		}
+		return true, r.define(defs)
+	}
+
+	result, err := r.evaluate(input)
+	if err != nil {
+		return true, err
+	}
+	fmt.Fprintln(r.out, result)
+
+	return true, nil
+}
+
+// Inputs continue over several lines until their brackets are balanced
+func bracketDepth(text string) int {
+	depth := 0
+	for _, c := range text {
+		switch c {
+		case '{', '(':
+			depth++
+		case '}', ')':
+			depth--
+		}
+	}
+
+	return depth
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func replCommand(opts *commandOptions, args []string) error {
+	r, err := newRepl(opts.runOptions(), os.Stdout)
+	if err != nil {
+		return err
+	}
+	for _, path := range args {
+		err := r.load(path)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Prompts would only clutter the output when input is piped in
+	interactive := isTerminal(os.Stdin)
+	prompt := func(s string) {
+		if interactive {
+			fmt.Fprint(os.Stdout, s)
+		}
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	input := ""
+	prompt("> ")
+	for scanner.Scan() {
+		input += scanner.Text() + "\n"
+		if bracketDepth(input) > 0 {
+			prompt("| ")
+			continue
+		}
+
+		more, err := r.handle(input)
+		input = ""
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		if !more {
+			return nil
+		}
+		prompt("> ")
+	}
+
+	return scanner.Err()
+}
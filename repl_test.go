@@ -0,0 +1,61 @@
+package main
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// The global of an evaluated expression is dropped once its value is
+// printed, so the collector may reclaim the value, and so is its code
+func TestReplForgetsResults(t *testing.T) {
+	r, err := newRepl(runOptions{traceResult, overflowWrap, 8, interpDense}, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.handle("defn double x = { x * 2 }"); err != nil {
+		t.Fatal(err)
+	}
+	mark := r.vm.mark()
+
+	inputs := []struct {
+		text string
+		want string
+	}{
+		{"(\\x -> x + 1) 41", "42"},
+		{"\\x -> x", "<function> : "},
+		{"1 / 0", ""},
+		{"2 * 3", "6"},
+		{"double (\\x -> x + 1) 1", ""},
+		{"double 21", "42"},
+	}
+	for _, in := range inputs {
+		got, err := r.evaluate(in.text)
+		if in.want == "" {
+			if err == nil {
+				t.Errorf("%q: expected an error", in.text)
+			}
+		} else if err != nil {
+			t.Errorf("%q: %v", in.text, err)
+		} else if !strings.HasPrefix(got, in.want) {
+			t.Errorf("%q: got %q, want %q", in.text, got, in.want)
+		}
+	}
+
+	for name := range r.vm.globalMap {
+		if strings.HasPrefix(name, "$it") {
+			t.Errorf("%s is still a global", name)
+		}
+	}
+	for _, g := range programGlobals(r.prog.defs) {
+		if strings.HasPrefix(g.name, "$it") {
+			t.Errorf("%s is still defined", g.name)
+		}
+	}
+	if got := r.vm.mark(); got != mark {
+		t.Errorf("dense code and tables grew from %v to %v", mark, got)
+	}
+	if r.prog.env.lookup("$it1") != nil {
+		t.Errorf("$it1 is still bound")
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/pocket7878/fngo/rt"
+)
+
+const defaultPrintDepth = 32
+
+// Renders values on the VM heap as source level terms, evaluating them as
+// far as needed. Constructor names are found through the type of the value.
+type resultPrinter struct {
+	vm       *gVM
+	mgr      *typMgr
+	env      *typEnv
+	datas    map[string]*definitionData
+	maxDepth int
+}
+
+func newResultPrinter(vm *gVM, mgr *typMgr, env *typEnv, prog []definition, maxDepth int) *resultPrinter {
+	datas := make(map[string]*definitionData)
+	for _, d := range prog {
+		if data, ok := d.(*definitionData); ok {
+			datas[data.name] = data
+		}
+	}
+
+	// Printing unifies the types of constructors with the types of values,
+	// which must not leak into the program
+	return &resultPrinter{vm, mgr.clone(), env, datas, maxDepth}
+}
+
+// Evaluate the node at a to weak head normal form
+func (g *gVM) evaluate(a addrType) (addrType, error) {
+	g.stack.Push(a)
+	var err error
+	if g.interp == interpDense {
+		g.pc = denseEvalStub
+		err = g.runDense()
+	} else {
+		g.pushInst(instEval{})
+		err = g.run()
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return g.stack.Pop(), nil
+}
+
+func (p *resultPrinter) print(a addrType, t typ) (string, error) {
+	return rt.Print(resultTerm{p, a, t}, p.maxDepth)
+}
+
+// Node on the VM heap, with its type if known
+type resultTerm struct {
+	p *resultPrinter
+	a addrType
+	t typ
+}
+
+// A data node is pushed on the VM stack until its fields are printed, since
+// the collector only sees nodes reachable from the stack and the globals
+func (r resultTerm) Eval() (rt.Shape, error) {
+	vm := r.p.vm
+	a, err := vm.evaluate(r.a)
+	if err != nil {
+		return rt.Shape{}, err
+	}
+
+	switch n := vm.heap.Nodes[a].(type) {
+	case *nodeNum:
+		return rt.Shape{Kind: rt.ShapeNumber, Number: fmt.Sprintf("%d", n.Value)}, nil
+	case *nodeBigNum:
+		return rt.Shape{Kind: rt.ShapeNumber, Number: n.value.String()}, nil
+	case *nodeData:
+		name, fieldTypes := r.p.constructorOf(n, r.t)
+		fields := make([]rt.Term, len(n.Fields))
+		for i, field := range n.Fields {
+			var ft typ
+			if i < len(fieldTypes) {
+				ft = fieldTypes[i]
+			}
+			fields[i] = resultTerm{r.p, field, ft}
+		}
+
+		vm.stack.Push(a)
+		return rt.Shape{Kind: rt.ShapeData, Name: name, Fields: fields, Release: func() { vm.stack.Pop() }}, nil
+	default:
+		return rt.Shape{Kind: rt.ShapeFunction}, nil
+	}
+}
+
+// Name and field types of the constructor which built n, if the type is known
+func (p *resultPrinter) constructorOf(n *nodeData, t typ) (string, []typ) {
+	unknown := fmt.Sprintf("<tag %d>", n.Tag)
+	if t == nil {
+		return unknown, nil
+	}
+
+	var v *typVar
+	dataTyp, ok := dataTypeOf(p.mgr.resolve(t, &v))
+	if !ok {
+		return unknown, nil
+	}
+
+	data, ok := p.datas[dataTyp.name]
+	if !ok {
+		return unknown, nil
+	}
+
+	for _, c := range data.constructors {
+		if c.tag != n.Tag {
+			continue
+		}
+
+		s := p.env.lookup(c.name)
+		if s == nil {
+			return c.name, nil
+		}
+
+		fieldTypes := make([]typ, 0)
+		constrTyp := s.instantiate(p.mgr)
+		for {
+			arr, ok := constrTyp.(*typArr)
+			if !ok {
+				break
+			}
+			fieldTypes = append(fieldTypes, arr.left)
+			constrTyp = arr.right
+		}
+
+		if p.mgr.unify(constrTyp, t) != nil {
+			return c.name, nil
+		}
+
+		return c.name, fieldTypes
+	}
+
+	return unknown, nil
+}
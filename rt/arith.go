@@ -0,0 +1,87 @@
+package rt
+
+import "fmt"
+
+type Op = int
+
+const (
+	OpPlus Op = iota
+	OpMinus
+	OpTimes
+	OpDivide
+	OpEq
+	OpNe
+	OpLt
+	OpLe
+	OpGt
+	OpGe
+)
+
+// Tags of the constructors of the built-in Bool type
+const (
+	FalseTag = iota
+	TrueTag
+)
+
+var opSymbols = []string{"+", "-", "*", "/", "==", "/=", "<", "<=", ">", ">="}
+
+// Operator as written in source
+func OpSymbol(op Op) string {
+	if op < 0 || op >= len(opSymbols) {
+		return fmt.Sprintf("%d", op)
+	}
+
+	return opSymbols[op]
+}
+
+func IsComparison(op Op) bool {
+	return op >= OpEq && op <= OpGe
+}
+
+// Apply an arithmetic operator to two ints, wrapping around on overflow
+func IntArith(op Op, l int, r int) (Node, error) {
+	switch op {
+	case OpPlus:
+		return &Num{l + r}, nil
+	case OpMinus:
+		return &Num{l - r}, nil
+	case OpTimes:
+		return &Num{l * r}, nil
+	case OpDivide:
+		if r == 0 {
+			return nil, fmt.Errorf("Division by zero")
+		}
+		return &Num{l / r}, nil
+	default:
+		return nil, fmt.Errorf("Unsupported BinOp: %d", op)
+	}
+}
+
+func Bool(b bool) Node {
+	if b {
+		return &Data{TrueTag, make([]Addr, 0)}
+	}
+
+	return &Data{FalseTag, make([]Addr, 0)}
+}
+
+// Bool data node for a comparison whose operands compare as c, which is
+// negative, zero or positive like the result of big.Int.Cmp
+func Compare(op Op, c int) (Node, error) {
+	switch op {
+	case OpEq:
+		return Bool(c == 0), nil
+	case OpNe:
+		return Bool(c != 0), nil
+	case OpLt:
+		return Bool(c < 0), nil
+	case OpLe:
+		return Bool(c <= 0), nil
+	case OpGt:
+		return Bool(c > 0), nil
+	case OpGe:
+		return Bool(c >= 0), nil
+	default:
+		return nil, fmt.Errorf("Unsupported comparison: %d", op)
+	}
+}
@@ -0,0 +1,126 @@
+package rt
+
+import "fmt"
+
+const DefaultGCThreshold = 1024
+
+type GCStats struct {
+	Collections int
+	Collected   int
+	Live        int
+	MaxLive     int
+}
+
+func (s GCStats) String() string {
+	return fmt.Sprintf("collections: %d, collected: %d, live: %d, max live: %d",
+		s.Collections, s.Collected, s.Live, s.MaxLive)
+}
+
+// Nodes by address. The addresses of collected nodes are reused.
+type Heap struct {
+	Nodes map[Addr]Node
+	// Number of allocations between collections while few nodes are live
+	Threshold int
+	Stats     GCStats
+
+	free      Addr
+	freeList  []Addr
+	allocated int
+	nextGC    int
+}
+
+func NewHeap() *Heap {
+	return &Heap{
+		Nodes:     make(map[Addr]Node),
+		Threshold: DefaultGCThreshold,
+
+		freeList: make([]Addr, 0),
+		nextGC:   DefaultGCThreshold,
+	}
+}
+
+func (h *Heap) Alloc(n Node) Addr {
+	h.allocated++
+	a := h.free
+	if len(h.freeList) > 0 {
+		a = h.freeList[len(h.freeList)-1]
+		h.freeList = h.freeList[:len(h.freeList)-1]
+	} else {
+		h.free++
+	}
+	h.Nodes[a] = n
+
+	return a
+}
+
+// Overwrite the node at target with an indirection to a. An indirection
+// which would lead back to target, as in letrec x = x, is left a hole, so
+// unwinding it fails instead of looping.
+func (h *Heap) Update(target Addr, a Addr) {
+	for next := a; next != Hole; {
+		if next == target {
+			a = Hole
+			break
+		}
+		ind, ok := h.Nodes[next].(*Ind)
+		if !ok {
+			break
+		}
+		next = ind.Next
+	}
+
+	h.Nodes[target] = &Ind{a}
+}
+
+// Whether enough nodes are allocated since the last collection
+func (h *Heap) Due() bool {
+	return h.allocated >= h.nextGC
+}
+
+// Mark and sweep, keeping the nodes reachable from roots
+func (h *Heap) Collect(roots []Addr) {
+	marked := make(map[Addr]bool, len(h.Nodes))
+	work := make([]Addr, 0, len(roots))
+	work = append(work, roots...)
+
+	for len(work) > 0 {
+		a := work[len(work)-1]
+		work = work[:len(work)-1]
+		if marked[a] {
+			continue
+		}
+		marked[a] = true
+
+		switch n := h.Nodes[a].(type) {
+		case *App:
+			work = append(work, n.Left, n.Right)
+		case *Ind:
+			work = append(work, n.Next)
+		case *Data:
+			work = append(work, n.Fields...)
+		}
+	}
+
+	collected := 0
+	for a := range h.Nodes {
+		if !marked[a] {
+			delete(h.Nodes, a)
+			h.freeList = append(h.freeList, a)
+			collected++
+		}
+	}
+
+	live := len(h.Nodes)
+	h.Stats.Collections++
+	h.Stats.Collected += collected
+	h.Stats.Live = live
+	if live > h.Stats.MaxLive {
+		h.Stats.MaxLive = live
+	}
+
+	h.allocated = 0
+	h.nextGC = h.Threshold
+	if 2*live > h.nextGC {
+		h.nextGC = 2 * live
+	}
+}
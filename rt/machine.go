@@ -0,0 +1,277 @@
+package rt
+
+import "fmt"
+
+// Global whose code is a Go function, as written by fngo gen-go. The code
+// calls the instructions of the Machine and returns where the G-code would
+// Unwind.
+type Global struct {
+	Name  string
+	Arity int
+	Code  func(m *Machine)
+}
+
+func (a *Global) NodeTag() NodeTag {
+	return TagGlobal
+}
+
+func (a Global) String() string {
+	return fmt.Sprintf("NGlobal %s/%d", a.Name, a.Arity)
+}
+
+// Error in the code of a global. The instructions of a Machine panic with
+// it, and Run returns it. It reads like the runtime errors of fngo run, with
+// the frame of the failing evaluation as the stack.
+type Error struct {
+	Global string
+	Inst   string
+	Stack  []Addr
+	Nodes  []Node
+	Err    error
+}
+
+func (e *Error) Error() string {
+	result := "Runtime error"
+	if e.Global != "" {
+		result += fmt.Sprintf(" in %s", e.Global)
+	}
+	result += fmt.Sprintf(" at %s: %v\n", e.Inst, e.Err)
+	result += "stack:"
+	for i := len(e.Stack) - 1; i >= 0; i-- {
+		result += fmt.Sprintf("\n\t%d: %v", e.Stack[i], e.Nodes[i])
+	}
+
+	return result
+}
+
+func (e *Error) Cause() error {
+	return e.Err
+}
+
+// Runs compiled globals. Like the C runtime of fngo build, every evaluation
+// shares one stack, and Eval keeps its frame on the Go stack instead of a
+// dump. Instructions collect before they pop their operands, so the stack
+// and the globals are the only roots.
+type Machine struct {
+	heap    *Heap
+	stack   *Stack
+	globals []Addr
+	// Start of the frame of the innermost Eval
+	fp int
+	// Name of the global whose code is running
+	current string
+}
+
+func NewMachine(globals []Global) *Machine {
+	m := &Machine{NewHeap(), NewStack(), make([]Addr, 0), 0, ""}
+	for _, g := range globals {
+		g := g
+		m.globals = append(m.globals, m.heap.Alloc(&g))
+	}
+
+	return m
+}
+
+func (m *Machine) Stats() GCStats {
+	return m.heap.Stats
+}
+
+func (m *Machine) fail(inst string, format string, args ...interface{}) {
+	frame := make([]Addr, len(m.stack.Data)-m.fp)
+	copy(frame, m.stack.Data[m.fp:])
+	nodes := make([]Node, len(frame))
+	for i, a := range frame {
+		nodes[i] = m.heap.Nodes[a]
+	}
+
+	panic(&Error{m.current, inst, frame, nodes, fmt.Errorf(format, args...)})
+}
+
+func (m *Machine) maybeCollect() {
+	if !m.heap.Due() {
+		return
+	}
+
+	roots := make([]Addr, 0, len(m.stack.Data)+len(m.globals))
+	roots = append(roots, m.stack.Data...)
+	roots = append(roots, m.globals...)
+	m.heap.Collect(roots)
+}
+
+func (m *Machine) PushInt(v int) {
+	m.maybeCollect()
+	m.stack.Push(m.heap.Alloc(&Num{v}))
+}
+
+func (m *Machine) PushGlobal(i int) {
+	m.stack.Push(m.globals[i])
+}
+
+func (m *Machine) Push(offset int) {
+	m.stack.Push(m.stack.Peek(offset))
+}
+
+func (m *Machine) Pop(n int) {
+	m.stack.Data = m.stack.Data[:len(m.stack.Data)-n]
+}
+
+func (m *Machine) MkApp() {
+	m.maybeCollect()
+	a0 := m.stack.Pop()
+	a1 := m.stack.Pop()
+	m.stack.Push(m.heap.Alloc(&App{a0, a1}))
+}
+
+func (m *Machine) Update(offset int) {
+	a := m.stack.Pop()
+	m.heap.Update(m.stack.Peek(offset), a)
+}
+
+func (m *Machine) Pack(tag int, size int) {
+	m.maybeCollect()
+	fields := make([]Addr, size)
+	for i := 0; i < size; i++ {
+		fields[i] = m.stack.Pop()
+	}
+	m.stack.Push(m.heap.Alloc(&Data{tag, fields}))
+}
+
+func (m *Machine) data(inst string, a Addr) *Data {
+	n, ok := m.heap.Nodes[a].(*Data)
+	if !ok {
+		m.fail(inst, "Expected data node, got %v", m.heap.Nodes[a])
+	}
+
+	return n
+}
+
+func (m *Machine) Split() {
+	n := m.data("Split()", m.stack.Pop())
+	for i := len(n.Fields) - 1; i >= 0; i-- {
+		m.stack.Push(n.Fields[i])
+	}
+}
+
+// Tag of the data node on top of the stack, which selects the branch of a
+// Jump
+func (m *Machine) Tag() int {
+	return m.data("Jump()", m.stack.Peek(0)).Tag
+}
+
+func (m *Machine) NoBranch(tag int) {
+	m.fail("Jump()", "No branch for tag %d", tag)
+}
+
+func (m *Machine) Slide(n int) {
+	a := m.stack.Pop()
+	m.stack.Data = m.stack.Data[:len(m.stack.Data)-n]
+	m.stack.Push(a)
+}
+
+// Integers wrap around, as in the wrap overflow mode of the interpreter
+func (m *Machine) BinOp(op Op, pos string) {
+	inst := fmt.Sprintf("BinOp(%s)", OpSymbol(op))
+	m.maybeCollect()
+	l, lok := m.heap.Nodes[m.stack.Peek(0)].(*Num)
+	r, rok := m.heap.Nodes[m.stack.Peek(1)].(*Num)
+	if !lok || !rok {
+		m.fail(inst, "%s: Not a number", pos)
+	}
+
+	var result Node
+	var err error
+	if IsComparison(op) {
+		c := 0
+		switch {
+		case l.Value < r.Value:
+			c = -1
+		case l.Value > r.Value:
+			c = 1
+		}
+		result, err = Compare(op, c)
+	} else {
+		result, err = IntArith(op, l.Value, r.Value)
+	}
+	if err != nil {
+		m.fail(inst, "%s: %v", pos, err)
+	}
+
+	m.Pop(2)
+	m.stack.Push(m.heap.Alloc(result))
+}
+
+func (m *Machine) Eval() {
+	fp, current := m.fp, m.current
+	m.fp = len(m.stack.Data) - 1
+	m.unwind()
+	a := m.stack.Pop()
+	m.stack.Data = m.stack.Data[:m.fp]
+	m.stack.Push(a)
+	m.fp, m.current = fp, current
+}
+
+func (m *Machine) Alloc(n int) {
+	m.maybeCollect()
+	for i := 0; i < n; i++ {
+		m.stack.Push(m.heap.Alloc(&Ind{Hole}))
+	}
+}
+
+// Code of a global returns to this loop where the G-code has Unwind
+func (m *Machine) unwind() {
+	for {
+		data := m.stack.Data
+		switch n := m.heap.Nodes[data[len(data)-1]].(type) {
+		case *App:
+			m.stack.Push(n.Left)
+		case *Ind:
+			if n.Next == Hole {
+				m.fail("Unwind()", "Value depends on itself")
+			}
+			data[len(data)-1] = n.Next
+		case *Global:
+			if len(data)-m.fp <= n.Arity {
+				// A partial application is in WHNF, its value is the root
+				m.stack.Data = data[:m.fp+1]
+				return
+			}
+			for i := 1; i <= n.Arity; i++ {
+				app, ok := m.heap.Nodes[data[len(data)-1-i]].(*App)
+				if !ok {
+					m.fail("Unwind()", "Expected application node for argument %d of %s", i, n.Name)
+				}
+				data[len(data)-i] = app.Right
+			}
+			m.current = n.Name
+			n.Code(m)
+		default:
+			return
+		}
+	}
+}
+
+// Called by the Run function of a generated package. A runtime error
+// panics out of the instructions, and is recovered here and returned.
+func (m *Machine) Run(entry int, args []int, printers []Printer, result int, depth int) (s string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(*Error)
+			if !ok {
+				panic(r)
+			}
+			m.stack = NewStack()
+			m.fp = 0
+			m.current = ""
+			err = e
+		}
+	}()
+
+	m.stack.Push(m.globals[entry])
+	for _, a := range args {
+		m.maybeCollect()
+		f := m.stack.Pop()
+		m.stack.Push(m.heap.Alloc(&App{f, m.globals[a]}))
+	}
+
+	return Print(machineTerm{m, printers, result, m.stack.Pop()}, depth)
+}
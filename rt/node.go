@@ -0,0 +1,77 @@
+// Package rt holds the graph of the G-machine: the nodes on the heap, the
+// stack and the collector. The interpreter of fngo runs on it, and the Go
+// packages written by fngo gen-go call the instructions of its Machine.
+package rt
+
+import "fmt"
+
+type Addr = int
+type NodeTag = int
+
+// Address of the placeholder that Alloc leaves in a fresh indirection.
+const Hole Addr = -1
+
+const (
+	TagApp NodeTag = iota
+	TagNum
+	TagGlobal
+	TagInd
+	TagData
+)
+
+type (
+	Node interface {
+		fmt.Stringer
+		NodeTag() NodeTag
+	}
+
+	App struct {
+		Left  Addr
+		Right Addr
+	}
+
+	Num struct {
+		Value int
+	}
+
+	Ind struct {
+		Next Addr
+	}
+
+	Data struct {
+		Tag    int
+		Fields []Addr
+	}
+)
+
+func (a *App) NodeTag() NodeTag {
+	return TagApp
+}
+
+func (a *Num) NodeTag() NodeTag {
+	return TagNum
+}
+
+func (a *Ind) NodeTag() NodeTag {
+	return TagInd
+}
+
+func (a *Data) NodeTag() NodeTag {
+	return TagData
+}
+
+func (a App) String() string {
+	return fmt.Sprintf("NApp %d %d", a.Left, a.Right)
+}
+
+func (a Num) String() string {
+	return fmt.Sprintf("NInt %d", a.Value)
+}
+
+func (a Ind) String() string {
+	return fmt.Sprintf("NInd %d", a.Next)
+}
+
+func (a Data) String() string {
+	return fmt.Sprintf("NData %d %v", a.Tag, a.Fields)
+}
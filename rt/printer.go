@@ -0,0 +1,139 @@
+package rt
+
+import (
+	"fmt"
+	"strings"
+)
+
+type PrinterKind = int
+
+const (
+	PrinterInt PrinterKind = iota
+	PrinterData
+	// Type unknown, as for type variables and functions. Constructors are
+	// shown by tag.
+	PrinterUnknown
+)
+
+type ShapeKind = int
+
+const (
+	ShapeNumber ShapeKind = iota
+	ShapeData
+	ShapeFunction
+)
+
+type (
+	// Compiled programs print results the way the interpreter does. There
+	// is a printer for every type that can occur in the result, and the
+	// fields of a constructor are printed with the printers at the indices
+	// in Fields.
+	Printer struct {
+		Kind         PrinterKind
+		Constructors []Constructor
+	}
+
+	Constructor struct {
+		Tag    int
+		Name   string
+		Fields []int
+	}
+
+	// Value to be printed, which is evaluated only as far as Print needs
+	Term interface {
+		Eval() (Shape, error)
+	}
+
+	// What a Term evaluated to. Release, if set, is called once the fields
+	// are printed.
+	Shape struct {
+		Kind    ShapeKind
+		Number  string
+		Name    string
+		Fields  []Term
+		Release func()
+	}
+
+	machineTerm struct {
+		m        *Machine
+		printers []Printer
+		printer  int
+		a        Addr
+	}
+)
+
+// Render t as a source level term. Fields deeper than maxDepth are printed
+// as "...", so that an infinite structure ends.
+func Print(t Term, maxDepth int) (string, error) {
+	return printTerm(t, maxDepth, 0)
+}
+
+func printTerm(t Term, maxDepth int, depth int) (string, error) {
+	s, err := t.Eval()
+	if err != nil {
+		return "", err
+	}
+	if s.Release != nil {
+		defer s.Release()
+	}
+
+	switch {
+	case s.Kind == ShapeNumber:
+		return s.Number, nil
+	case s.Kind != ShapeData:
+		return "<function>", nil
+	case depth >= maxDepth && len(s.Fields) > 0:
+		return "...", nil
+	}
+
+	parts := []string{s.Name}
+	for _, f := range s.Fields {
+		fs, err := printTerm(f, maxDepth, depth+1)
+		if err != nil {
+			return "", err
+		}
+		if strings.Contains(fs, " ") || strings.HasPrefix(fs, "-") {
+			fs = "(" + fs + ")"
+		}
+		parts = append(parts, fs)
+	}
+
+	return strings.Join(parts, " "), nil
+}
+
+// The node stays on the stack until Release, since evaluating its fields
+// may collect
+func (t machineTerm) Eval() (Shape, error) {
+	m := t.m
+	m.stack.Push(t.a)
+	m.Eval()
+	release := func() { m.stack.Pop() }
+
+	switch n := m.heap.Nodes[m.stack.Peek(0)].(type) {
+	case *Num:
+		return Shape{Kind: ShapeNumber, Number: fmt.Sprintf("%d", n.Value), Release: release}, nil
+	case *Data:
+		name := fmt.Sprintf("<tag %d>", n.Tag)
+		var fields []int
+		if t.printer >= 0 && t.printers[t.printer].Kind == PrinterData {
+			for _, c := range t.printers[t.printer].Constructors {
+				if c.Tag == n.Tag && len(c.Fields) == len(n.Fields) {
+					name, fields = c.Name, c.Fields
+					break
+				}
+			}
+		}
+
+		terms := make([]Term, len(n.Fields))
+		for i, a := range n.Fields {
+			f := -1
+			if fields != nil {
+				f = fields[i]
+			}
+			terms[i] = machineTerm{m, t.printers, f, a}
+		}
+		return Shape{Kind: ShapeData, Name: name, Fields: terms, Release: release}, nil
+	default:
+		return Shape{Kind: ShapeFunction, Release: release}, nil
+	}
+}
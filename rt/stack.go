@@ -0,0 +1,36 @@
+package rt
+
+import "fmt"
+
+type Stack struct {
+	Data []Addr
+}
+
+func NewStack() *Stack {
+	return &Stack{
+		make([]Addr, 0),
+	}
+}
+
+func (s *Stack) Push(a Addr) {
+	s.Data = append(s.Data, a)
+}
+
+func (s *Stack) Pop() Addr {
+	result := s.Data[len(s.Data)-1]
+	s.Data = s.Data[:len(s.Data)-1]
+
+	return result
+}
+
+func (s *Stack) Peek(offset int) Addr {
+	return s.Data[len(s.Data)-1-offset]
+}
+
+func (s *Stack) Require(n int) error {
+	if len(s.Data) < n {
+		return fmt.Errorf("Stack underflow: need %d, have %d", n, len(s.Data))
+	}
+
+	return nil
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 )
 
 type (
@@ -28,22 +30,41 @@ type (
 
 	typData struct {
 		typBase
+		arity        int
 		constructors map[string]typDataConstr
 	}
 
+	// Data type applied to type arguments, such as List Int
+	typApp struct {
+		constructor typ
+		args        []typ
+	}
+
 	typArr struct {
 		left  typ
 		right typ
 	}
 
+	typScheme struct {
+		forall   []string
+		monotype typ
+	}
+
 	typMgr struct {
 		lastID int
 		types  map[string]typ
 	}
 
+	// Types are rendered when the error is made, since the substitution
+	// they are resolved in goes on changing
 	unificationError struct {
-		left  typ
-		right typ
+		left  string
+		right string
+	}
+
+	infiniteTypeError struct {
+		variable string
+		t        string
 	}
 )
 
@@ -52,6 +73,10 @@ func (e unificationError) Error() string {
 	return fmt.Sprintf("Failed to unify type: %s with %s", e.left, e.right)
 }
 
+func (e infiniteTypeError) Error() string {
+	return fmt.Sprintf("Infinite type: %s occurs in %s", e.variable, e.t)
+}
+
 func newTypMgr() *typMgr {
 	return &typMgr{
 		0,
@@ -59,6 +84,16 @@ func newTypMgr() *typMgr {
 	}
 }
 
+// Copy of the substitution, to unify types without changing the original
+func (m *typMgr) clone() *typMgr {
+	types := make(map[string]typ, len(m.types))
+	for k, t := range m.types {
+		types[k] = t
+	}
+
+	return &typMgr{m.lastID, types}
+}
+
 func (m *typMgr) newTypName() string {
 	tmp := m.lastID
 	m.lastID++
@@ -113,6 +148,27 @@ func (m *typMgr) bind(s string, t typ) {
 	m.types[s] = t
 }
 
+// Bind v to t unless that would make an infinite type
+func (m *typMgr) bindChecked(v *typVar, t typ) error {
+	if other, ok := t.(*typVar); ok && other.name == v.name {
+		return nil
+	}
+
+	if m.occurs(v.name, t) {
+		return infiniteTypeError{v.typString(m), t.typString(m)}
+	}
+
+	m.bind(v.name, t)
+	return nil
+}
+
+func (m *typMgr) occurs(name string, t typ) bool {
+	free := make(map[string]bool)
+	m.freeVariables(t, free)
+
+	return free[name]
+}
+
 func (m *typMgr) unify(l typ, r typ) error {
 	var lvar *typVar
 	var rvar *typVar
@@ -121,11 +177,9 @@ func (m *typMgr) unify(l typ, r typ) error {
 	r = m.resolve(r, &rvar)
 
 	if lvar != nil {
-		m.bind(lvar.name, r)
-		return nil
+		return m.bindChecked(lvar, r)
 	} else if rvar != nil {
-		m.bind(rvar.name, l)
-		return nil
+		return m.bindChecked(rvar, l)
 	}
 
 	larr, larrOk := l.(*typArr)
@@ -139,6 +193,29 @@ func (m *typMgr) unify(l typ, r typ) error {
 		return m.unify(larr.right, rarr.right)
 	}
 
+	lapp, lappOk := l.(*typApp)
+	rapp, rappOk := r.(*typApp)
+
+	if lappOk && rappOk {
+		if len(lapp.args) != len(rapp.args) {
+			return unificationError{l.typString(m), r.typString(m)}
+		}
+
+		err := m.unify(lapp.constructor, rapp.constructor)
+		if err != nil {
+			return err
+		}
+
+		for i := range lapp.args {
+			err = m.unify(lapp.args[i], rapp.args[i])
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
 	lbase, lbaseOk := l.(*typBase)
 	ldata, ldataOk := l.(*typData)
 	rbase, rbaseOk := r.(*typBase)
@@ -160,7 +237,113 @@ func (m *typMgr) unify(l typ, r typ) error {
 		return nil
 	}
 
-	return unificationError{l, r}
+	return unificationError{l.typString(m), r.typString(m)}
+}
+
+func (m *typMgr) freeVariables(t typ, into map[string]bool) {
+	var v *typVar
+	t = m.resolve(t, &v)
+	if v != nil {
+		into[v.name] = true
+		return
+	}
+
+	if arr, ok := t.(*typArr); ok {
+		m.freeVariables(arr.left, into)
+		m.freeVariables(arr.right, into)
+	}
+
+	if app, ok := t.(*typApp); ok {
+		for _, a := range app.args {
+			m.freeVariables(a, into)
+		}
+	}
+}
+
+func (m *typMgr) substitute(t typ, subst map[string]typ) typ {
+	var v *typVar
+	t = m.resolve(t, &v)
+	if v != nil {
+		if it, ok := subst[v.name]; ok {
+			return it
+		}
+		return v
+	}
+
+	if arr, ok := t.(*typArr); ok {
+		return &typArr{
+			m.substitute(arr.left, subst),
+			m.substitute(arr.right, subst),
+		}
+	}
+
+	if app, ok := t.(*typApp); ok {
+		args := make([]typ, len(app.args))
+		for i, a := range app.args {
+			args[i] = m.substitute(a, subst)
+		}
+		return &typApp{app.constructor, args}
+	}
+
+	return t
+}
+
+// Data type of values of type t, looking through type applications
+func dataTypeOf(t typ) (*typData, bool) {
+	if app, ok := t.(*typApp); ok {
+		t = app.constructor
+	}
+
+	data, ok := t.(*typData)
+	return data, ok
+}
+
+// Quantify every type variable of t which is not free in e.
+// Passing a nil env generalizes all of them.
+func (m *typMgr) generalize(t typ, e *typEnv) *typScheme {
+	free := make(map[string]bool)
+	m.freeVariables(t, free)
+
+	envFree := make(map[string]bool)
+	if e != nil {
+		e.freeVariables(m, envFree)
+	}
+
+	forall := make([]string, 0)
+	for v := range free {
+		if !envFree[v] {
+			forall = append(forall, v)
+		}
+	}
+	sort.Strings(forall)
+
+	return &typScheme{forall, t}
+}
+
+// Type Scheme
+func (s *typScheme) instantiate(m *typMgr) typ {
+	if len(s.forall) == 0 {
+		return s.monotype
+	}
+
+	subst := make(map[string]typ, len(s.forall))
+	for _, v := range s.forall {
+		subst[v] = m.newTyp()
+	}
+
+	return m.substitute(s.monotype, subst)
+}
+
+func (s *typScheme) freeVariables(m *typMgr, into map[string]bool) {
+	free := make(map[string]bool)
+	m.freeVariables(s.monotype, free)
+	for _, v := range s.forall {
+		delete(free, v)
+	}
+
+	for v := range free {
+		into[v] = true
+	}
 }
 
 // Print type
@@ -185,23 +368,68 @@ func (a typData) String() string {
 	return fmt.Sprintf("TypData(%v)", a.name)
 }
 
+func (a typApp) String() string {
+	result := fmt.Sprintf("%v", a.constructor)
+	for _, arg := range a.args {
+		switch arg.(type) {
+		case *typArr, *typApp:
+			result += fmt.Sprintf(" (%v)", arg)
+		default:
+			result += fmt.Sprintf(" %v", arg)
+		}
+	}
+
+	return result
+}
+
 func (v typVar) typString(m *typMgr) string {
 	it, ok := m.types[v.name]
 	if ok {
 		return it.typString(m)
 	}
-	return fmt.Sprintf("TypVar(%s)", v.name)
+	return v.name
 }
 
 func (b typBase) typString(m *typMgr) string {
 	return b.name
 }
 
+func (a typApp) typString(m *typMgr) string {
+	result := a.constructor.typString(m)
+	for _, arg := range a.args {
+		var v *typVar
+		switch m.resolve(arg, &v).(type) {
+		case *typArr, *typApp:
+			result += fmt.Sprintf(" (%s)", arg.typString(m))
+		default:
+			result += fmt.Sprintf(" %s", arg.typString(m))
+		}
+	}
+
+	return result
+}
+
+// Arrows associate to the right, so only an arrow on the left needs parentheses
 func (a typArr) typString(m *typMgr) string {
-	switch a.right.(type) {
+	var v *typVar
+	switch m.resolve(a.left, &v).(type) {
 	case *typArr:
-		return fmt.Sprintf("%v -> (%v)", a.left.typString(m), a.right.typString(m))
+		return fmt.Sprintf("(%v) -> %v", a.left.typString(m), a.right.typString(m))
 	default:
 		return fmt.Sprintf("%v -> %v", a.left.typString(m), a.right.typString(m))
 	}
 }
+
+func (s typScheme) String() string {
+	if len(s.forall) == 0 {
+		return fmt.Sprintf("%v", s.monotype)
+	}
+	return fmt.Sprintf("forall %s . %v", strings.Join(s.forall, " "), s.monotype)
+}
+
+func (s typScheme) typString(m *typMgr) string {
+	if len(s.forall) == 0 {
+		return s.monotype.typString(m)
+	}
+	return fmt.Sprintf("forall %s . %s", strings.Join(s.forall, " "), s.monotype.typString(m))
+}
@@ -10,11 +10,21 @@ func (a astInt) typecheck(mgr *typMgr, e *typEnv) (typ, error) {
 }
 
 func (a astLID) typecheck(mgr *typMgr, e *typEnv) (typ, error) {
-	return e.lookup(a.ID), nil
+	s := e.lookup(a.ID)
+	if s == nil {
+		return nil, fmt.Errorf("Unbound variable: %s", a.ID)
+	}
+
+	return s.instantiate(mgr), nil
 }
 
 func (a astUID) typecheck(mgr *typMgr, e *typEnv) (typ, error) {
-	return e.lookup(a.ID), nil
+	s := e.lookup(a.ID)
+	if s == nil {
+		return nil, fmt.Errorf("Unbound constructor: %s", a.ID)
+	}
+
+	return s.instantiate(mgr), nil
 }
 
 func (a astBinOp) typecheck(mgr *typMgr, e *typEnv) (typ, error) {
@@ -31,10 +41,11 @@ func (a astBinOp) typecheck(mgr *typMgr, e *typEnv) (typ, error) {
 		return nil, error
 	}
 
-	ftype := e.lookup(o)
-	if ftype == nil {
+	fscheme := e.lookup(o)
+	if fscheme == nil {
 		return nil, fmt.Errorf("Failed to typecheck bin op")
 	}
+	ftype := fscheme.instantiate(mgr)
 
 	returnType := mgr.newTyp()
 	arrowOne := &typArr{rtype, returnType}
@@ -82,20 +93,113 @@ func (a astCase) typecheck(mgr *typMgr, e *typEnv) (typ, error) {
 
 	for _, b := range a.branches {
 		newEnv := e.scope()
-		b.pat.match(caseType, mgr, newEnv)
+		err = b.pat.match(caseType, mgr, newEnv)
+		if err != nil {
+			return nil, atPosition(b.pat.getPosition(), err)
+		}
 		currBranchType, err := typeCheckCommon(b.expr, mgr, newEnv)
 		if err != nil {
 			return nil, err
 		}
 		err = mgr.unify(branchType, currBranchType)
 		if err != nil {
-			return nil, err
+			return nil, atPosition(b.expr.getPosition(), err)
 		}
 	}
 
 	return branchType, nil
 }
 
+func (a astLet) typecheck(mgr *typMgr, e *typEnv) (typ, error) {
+	newEnv := e.scope()
+
+	if a.rec {
+		for _, b := range a.bindings {
+			newEnv.bind(b.name, mgr.newTyp())
+		}
+	}
+
+	for _, b := range a.bindings {
+		if !a.rec {
+			bindingType, err := typeCheckCommon(b.expr, mgr, newEnv)
+			if err != nil {
+				return nil, err
+			}
+			newEnv.bindScheme(b.name, mgr.generalize(bindingType, newEnv))
+			continue
+		}
+
+		bindingType, err := typeCheckCommon(b.expr, mgr, newEnv)
+		if err != nil {
+			return nil, err
+		}
+		err = mgr.unify(newEnv.lookup(b.name).monotype, bindingType)
+		if err != nil {
+			return nil, atPosition(b.pos, err)
+		}
+	}
+
+	if a.rec {
+		for _, b := range a.bindings {
+			s := newEnv.lookup(b.name)
+			newEnv.bindScheme(b.name, mgr.generalize(s.monotype, e))
+		}
+	}
+
+	return typeCheckCommon(a.body, mgr, newEnv)
+}
+
+func (a astLambda) typecheck(mgr *typMgr, e *typEnv) (typ, error) {
+	newEnv := e.scope()
+	paramTypes := make([]typ, len(a.params))
+
+	for i, p := range a.params {
+		paramTypes[i] = mgr.newTyp()
+		newEnv.bind(p, paramTypes[i])
+	}
+
+	bodyType, err := typeCheckCommon(a.body, mgr, newEnv)
+	if err != nil {
+		return nil, err
+	}
+
+	var fullType typ = bodyType
+	for i := len(paramTypes) - 1; i >= 0; i-- {
+		fullType = &typArr{paramTypes[i], fullType}
+	}
+
+	return fullType, nil
+}
+
+func (a astIf) typecheck(mgr *typMgr, e *typEnv) (typ, error) {
+	condType, err := typeCheckCommon(a.cond, mgr, e)
+	if err != nil {
+		return nil, err
+	}
+
+	err = mgr.unify(condType, e.lookupType("Bool"))
+	if err != nil {
+		return nil, atPosition(a.cond.getPosition(), err)
+	}
+
+	thenType, err := typeCheckCommon(a.thenExpr, mgr, e)
+	if err != nil {
+		return nil, err
+	}
+
+	elseType, err := typeCheckCommon(a.elseExpr, mgr, e)
+	if err != nil {
+		return nil, err
+	}
+
+	err = mgr.unify(thenType, elseType)
+	if err != nil {
+		return nil, atPosition(a.elseExpr.getPosition(), err)
+	}
+
+	return thenType, nil
+}
+
 func (d *definitionDefn) typecheckFirst(mgr *typMgr, e *typEnv) {
 	d.returnType = mgr.newTyp()
 	var fullType typ = d.returnType
@@ -121,44 +225,90 @@ func (d *definitionDefn) typecheckSecond(mgr *typMgr, e *typEnv) error {
 	if err != nil {
 		return err
 	}
-	return mgr.unify(d.returnType, bodyType)
+
+	err = mgr.unify(d.returnType, bodyType)
+	if err != nil {
+		return atPosition(d.pos, err)
+	}
+
+	return nil
 }
 
+// Generalize once every definition of the group has been typechecked
+func (d *definitionDefn) generalize(mgr *typMgr, e *typEnv) {
+	s := e.lookup(d.name)
+	e.bindScheme(d.name, mgr.generalize(s.monotype, nil))
+}
+
+// Register the data type, so that constructors of other data types can
+// refer to it
 func (d *definitionData) typecheckFirst(mgr *typMgr, e *typEnv) {
-	thisType := typData{
+	thisType := &typData{
 		typBase{
 			d.name,
 		},
+		len(d.params),
 		make(map[string]typDataConstr, 0),
 	}
-	returnType := &thisType
+	d.nodeTyp = thisType
 	nextTag := 0
 
 	for i := 0; i < len(d.constructors); i++ {
 		c := &d.constructors[i]
 		c.tag = nextTag
-		thisType.constructors[c.name] = typDataConstr{nextTag + 1}
+		thisType.constructors[c.name] = typDataConstr{nextTag}
 		nextTag++
+	}
+
+	e.bindType(d.name, thisType)
+}
+
+func (d *definitionData) typecheckSecond(mgr *typMgr, e *typEnv) error {
+	vars := make(map[string]typ, len(d.params))
+	args := make([]typ, len(d.params))
+	forall := make([]string, len(d.params))
+
+	for i, p := range d.params {
+		if _, ok := vars[p]; ok {
+			return atPosition(d.pos, fmt.Errorf("Duplicate type parameter %s in %s", p, d.name))
+		}
+		v := mgr.newTyp()
+		vars[p] = v
+		args[i] = v
+		forall[i] = v.name
+	}
+
+	var returnType typ = d.nodeTyp
+	if len(args) > 0 {
+		returnType = &typApp{d.nodeTyp, args}
+	}
 
+	for i := 0; i < len(d.constructors); i++ {
+		c := &d.constructors[i]
 		var fullType typ = returnType
 
 		for i := len(c.types) - 1; i >= 0; i-- {
-			ty := &typBase{c.types[i]}
+			ty, err := c.types[i].toType(e, vars)
+			if err != nil {
+				return atPosition(c.pos, fmt.Errorf("In constructor %s: %v", c.name, err))
+			}
 			fullType = &typArr{ty, fullType}
 		}
 
-		e.bind(c.name, fullType)
+		c.nodeTyp = fullType
+		e.bindScheme(c.name, &typScheme{forall, fullType})
 	}
-}
 
-func (d *definitionData) typecheckSecond(mgr *typMgr, e *typEnv) error {
 	return nil
 }
 
+func (d *definitionData) generalize(mgr *typMgr, e *typEnv) {
+}
+
 func typeCheckCommon(a ast, m *typMgr, e *typEnv) (typ, error) {
 	typ, err := a.typecheck(m, e)
 	if err != nil {
-		return nil, err
+		return nil, atPosition(a.getPosition(), err)
 	}
 	a.setNodeType(typ)
 
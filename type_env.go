@@ -1,11 +1,20 @@
 package main
 
 type typEnv struct {
-	names  map[string]typ
+	names  map[string]*typScheme
+	types  map[string]typ
 	parent *typEnv
 }
 
-func (e *typEnv) lookup(name string) typ {
+func newTypEnv() *typEnv {
+	return &typEnv{
+		make(map[string]*typScheme, 0),
+		make(map[string]typ, 0),
+		nil,
+	}
+}
+
+func (e *typEnv) lookup(name string) *typScheme {
 	it, ok := e.names[name]
 	if ok {
 		return it
@@ -18,13 +27,56 @@ func (e *typEnv) lookup(name string) typ {
 	return nil
 }
 
+// Bind monomorphic type
 func (e *typEnv) bind(name string, r typ) {
-	e.names[name] = r
+	e.names[name] = &typScheme{make([]string, 0), r}
+}
+
+func (e *typEnv) bindScheme(name string, s *typScheme) {
+	e.names[name] = s
+}
+
+func (e *typEnv) lookupType(name string) typ {
+	it, ok := e.types[name]
+	if ok {
+		return it
+	}
+
+	if e.parent != nil {
+		return e.parent.lookupType(name)
+	}
+
+	return nil
+}
+
+func (e *typEnv) bindType(name string, t typ) {
+	e.types[name] = t
 }
 
 func (e *typEnv) scope() *typEnv {
 	return &typEnv{
+		make(map[string]*typScheme, 0),
 		make(map[string]typ, 0),
 		e,
 	}
 }
+
+func (e *typEnv) freeVariables(mgr *typMgr, into map[string]bool) {
+	for _, s := range e.names {
+		s.freeVariables(mgr, into)
+	}
+
+	if e.parent != nil {
+		e.parent.freeVariables(mgr, into)
+	}
+}
+
+// Move the bindings of a scope into its parent
+func (e *typEnv) commit() {
+	for name, s := range e.names {
+		e.parent.names[name] = s
+	}
+	for name, t := range e.types {
+		e.parent.types[name] = t
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func typecheckSource(src string) (*typMgr, *typEnv, error) {
+	prog, err := parseProgram("test.fn", strings.NewReader(src))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return typecheckProgram(append(builtinDefinitions(), prog...))
+}
+
+func TestTypecheck(t *testing.T) {
+	cases := []struct {
+		src  string
+		name string
+		typ  string
+	}{
+		{"defn id x = { x }", "id", "forall b . b -> b"},
+		{"defn const x y = { x }", "const", "forall b c . c -> b -> c"},
+		{"defn main = { (\\x -> x + 1) 2 }", "main", "Int"},
+		{"defn main = { letrec f = \\x -> if x == 0 then 1 else x * f (x - 1) in f 5 }", "main", "Int"},
+		{"defn main = { let id = \\x -> x in id id 1 }", "main", "Int"},
+		{"defn even n = { if n == 0 then True else odd (n - 1) }\ndefn odd n = { if n == 0 then False else even (n - 1) }", "odd", "Int -> Bool"},
+		{"data List a = { Nil, Cons a (List a) }\ndefn single x = { Cons x Nil }", "single", "forall d . d -> List d"},
+	}
+
+	for _, c := range cases {
+		mgr, e, err := typecheckSource(c.src)
+		if err != nil {
+			t.Errorf("%q: %v", c.src, err)
+			continue
+		}
+		if got := e.lookup(c.name).typString(mgr); got != c.typ {
+			t.Errorf("%q: %s has type %s, want %s", c.src, c.name, got, c.typ)
+		}
+	}
+}
+
+func TestTypecheckErrors(t *testing.T) {
+	cases := []struct {
+		src string
+		err string
+	}{
+		{"defn f x = { f }", "test.fn:1:1: Infinite type: a occurs in b -> a"},
+		{"defn main = { letrec f = \\x -> f in 1 }", "test.fn:1:22: Infinite type: b occurs in c -> b"},
+		{"defn main = { \\x -> x x }", "test.fn:1:21: Infinite type: b occurs in b -> c"},
+		{"data List a = { Nil }\ndefn main = { 1 + Nil }", "test.fn:2:17: Failed to unify type: List c with Int"},
+		{"defn main = { if 1 then 2 else 3 }", "test.fn:1:18: Failed to unify type: Int with Bool"},
+	}
+
+	for _, c := range cases {
+		_, _, err := typecheckSource(c.src)
+		if err == nil {
+			t.Errorf("%q: expected an error", c.src)
+			continue
+		}
+		if !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%q: got error %q, want %q", c.src, err, c.err)
+		}
+	}
+}
+
+func TestOccursCheck(t *testing.T) {
+	mgr := newTypMgr()
+	a := mgr.newTyp()
+	b := mgr.newTyp()
+
+	err := mgr.unify(a, &typArr{b, a})
+	if _, ok := err.(infiniteTypeError); !ok {
+		t.Fatalf("unifying a with b -> a: got %v, want an infinite type error", err)
+	}
+	if got, want := err.Error(), "Infinite type: a occurs in b -> a"; got != want {
+		t.Errorf("got error %q, want %q", got, want)
+	}
+	if _, ok := mgr.types[a.name]; ok {
+		t.Errorf("a is bound after the failed unification")
+	}
+}
+
+func TestUnifyVariable(t *testing.T) {
+	mgr := newTypMgr()
+	a := mgr.newTyp()
+	b := mgr.newTyp()
+
+	if err := mgr.unify(a, a); err != nil {
+		t.Errorf("unifying a with itself: %v", err)
+	}
+	if err := mgr.unify(a, &typArr{b, b}); err != nil {
+		t.Errorf("unifying a with b -> b: %v", err)
+	}
+	if err := mgr.unify(b, a); err == nil {
+		t.Errorf("unifying b with a, which is b -> b, should fail")
+	}
+}
@@ -2,84 +2,137 @@ package main
 
 import (
 	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"text/scanner"
+
+	"github.com/pocket7878/fngo/rt"
 )
 
-type addrType = int
-type nodeTagType = int
+type addrType = rt.Addr
+type nodeTagType = rt.NodeTag
+type traceLevel = int
+type overflowMode = int
+
+// Address of the placeholder that Alloc leaves in a fresh indirection.
+const holeAddr = rt.Hole
 
 const (
-	nodeAppTag nodeTagType = iota
-	nodeNumTag
-	nodeGlobalTag
-	nodeIndTag
-	nodeDataTag
+	nodeAppTag    = rt.TagApp
+	nodeNumTag    = rt.TagNum
+	nodeGlobalTag = rt.TagGlobal
+	nodeIndTag    = rt.TagInd
+	nodeDataTag   = rt.TagData
+	nodeBigNumTag = rt.TagData + 1
 )
 
-type (
-	node interface {
-		fmt.Stringer
-		getNodeTag() nodeTagType
-	}
+const (
+	traceResult traceLevel = iota
+	traceInst
+	traceState
+)
 
-	nodeApp struct {
-		left  addrType
-		right addrType
-	}
+const (
+	overflowWrap overflowMode = iota
+	overflowTrap
+	overflowBignum
+)
 
-	nodeNum struct {
-		value int
+// The graph is shared with compiled programs, see the rt package
+type (
+	node     = rt.Node
+	nodeApp  = rt.App
+	nodeNum  = rt.Num
+	nodeInd  = rt.Ind
+	nodeData = rt.Data
+	stack    = rt.Stack
+)
+
+type (
+	// Integer which does not fit in nodeNum, only made in overflowBignum mode
+	nodeBigNum struct {
+		value *big.Int
 	}
 
 	nodeGlobal struct {
+		name  string
 		arity int
 		code  []inst
-	}
-
-	nodeInd struct {
-		next addrType
-	}
-
-	nodeData struct {
-		tag   int
-		array []addrType
-	}
-
-	stack struct {
-		data []addrType
+		// Start of the dense encoding of code
+		entry int
 	}
 
 	dumpEntry struct {
-		insts []inst
-		stack stack
+		insts  []inst
+		stack  stack
+		global string
+		pc     int
 	}
 
 	gVM struct {
 		insts     []inst
 		stack     *stack
 		dump      []dumpEntry
-		heap      map[int]node
+		heap      *rt.Heap
 		globalMap map[string]int
-		freeAddr  int
+
+		trace    traceLevel
+		traceOut io.Writer
+
+		// Name of the global whose code is being executed
+		global string
+
+		overflow overflowMode
+
+		interp interpreter
+		// Dense encoding of the code of every global, see vm_dense.go
+		code        []int
+		pc          int
+		symbols     map[string]int
+		symbolNames []string
+		symbolAddrs []addrType
+		positions   []scanner.Position
 	}
 )
 
 func newGVM() *gVM {
 	return &gVM{
 		insts:     make([]inst, 0),
-		stack:     newStack(),
+		stack:     rt.NewStack(),
 		dump:      make([]dumpEntry, 0),
-		heap:      make(map[int]node),
+		heap:      rt.NewHeap(),
 		globalMap: make(map[string]int),
-		freeAddr:  0,
+
+		trace:    traceResult,
+		traceOut: os.Stderr,
+
+		overflow: overflowWrap,
+
+		interp:      interpDense,
+		code:        []int{opEval, opHalt, opUnwind},
+		symbols:     make(map[string]int),
+		symbolNames: make([]string, 0),
+		symbolAddrs: make([]addrType, 0),
+		positions:   make([]scanner.Position, 0),
 	}
 }
 
+// Drop the state of an aborted evaluation. The heap and the globals are kept.
+func (g *gVM) reset() {
+	g.insts = make([]inst, 0)
+	g.stack = rt.NewStack()
+	g.dump = make([]dumpEntry, 0)
+	g.global = ""
+	g.pc = denseEvalStub
+}
+
 func (g gVM) String() string {
 	result := fmt.Sprintf("------ VM -----------\n")
 	result += fmt.Sprintf("inst: %v\n", g.insts)
 	result += fmt.Sprintf("stack: %v\n", g.stack)
 	result += fmt.Sprintf("dump: %v\n", g.dump)
-	result += fmt.Sprintf("heap: %v\n", g.heap)
+	result += fmt.Sprintf("heap: %v\n", g.heap.Nodes)
 	result += fmt.Sprintf("globalMap: %v\n", g.globalMap)
 	result += fmt.Sprintf("======================\n")
 
@@ -91,33 +144,38 @@ func (g *gVM) pushInst(i inst) {
 }
 
 func (g *gVM) addGlobal(name string, arity int, inst []inst) {
-	a := g.newFreeAddr()
-	g.heap[a] = &nodeGlobal{arity, inst}
+	a := g.heap.Alloc(&nodeGlobal{name, arity, inst, g.assemble(inst)})
 	g.globalMap[name] = a
+	g.symbolAddrs[g.symbol(name)] = a
 }
 
-func (g *gVM) run() {
+func (g *gVM) run() error {
 	for {
 		if len(g.insts) == 0 {
 			break
 		}
+		g.maybeCollect()
 		head := g.peekInst()
-		fmt.Println("------- START --------------")
-		fmt.Println("BEFORE VM")
-		fmt.Printf("%v", g)
-		fmt.Printf("Execute: %v\n", head)
-		head.execute(g)
-		fmt.Println("AFTER VM")
-		fmt.Printf("%v", g)
-		fmt.Printf("------- END --------------\n\n")
+		g.tracef(traceState, "------- START --------------\n")
+		g.tracef(traceState, "BEFORE VM\n%v", g)
+		g.tracef(traceInst, "Execute: %v\n", head)
+		err := head.execute(g)
+		if err != nil {
+			return g.runtimeError(head.String(), err)
+		}
+		g.tracef(traceState, "AFTER VM\n%v", g)
+		g.tracef(traceState, "------- END --------------\n\n")
 	}
+
+	return nil
 }
 
-func (g *gVM) newFreeAddr() int {
-	a := g.freeAddr
-	g.freeAddr++
+func (g *gVM) tracef(level traceLevel, format string, args ...interface{}) {
+	if g.trace < level {
+		return
+	}
 
-	return a
+	fmt.Fprintf(g.traceOut, format, args...)
 }
 
 func (g *gVM) peekInst() inst {
@@ -128,54 +186,69 @@ func (g *gVM) popInst() {
 	g.insts = g.insts[:len(g.insts)-1]
 }
 
-func (i instPushInt) execute(g *gVM) {
+func (i instPushInt) execute(g *gVM) error {
 	g.popInst()
-	a := g.newFreeAddr()
-	g.heap[a] = &nodeNum{i.value}
-	g.stack.push(a)
+	a := g.heap.Alloc(&nodeNum{Value: i.value})
+	g.stack.Push(a)
+
+	return nil
 }
 
-func (inst instPushGlobal) execute(g *gVM) {
-	g.popInst()
+func (inst instPushGlobal) execute(g *gVM) error {
 	a, ok := g.globalMap[inst.name]
 	if !ok {
-		panic(fmt.Errorf("Undefined function: %s", inst.name))
+		return fmt.Errorf("Undefined function: %s", inst.name)
 	}
-	g.stack.push(a)
+	g.popInst()
+	g.stack.Push(a)
+
+	return nil
 }
 
-func (inst instPush) execute(g *gVM) {
+func (inst instPush) execute(g *gVM) error {
+	if err := g.stack.Require(inst.offset + 1); err != nil {
+		return err
+	}
 	g.popInst()
-	an := g.stack.peek(inst.offset)
-	g.stack.push(an)
+	an := g.stack.Peek(inst.offset)
+	g.stack.Push(an)
+
+	return nil
 }
 
-func (inst instMkApp) execute(g *gVM) {
+func (inst instMkApp) execute(g *gVM) error {
+	if err := g.stack.Require(2); err != nil {
+		return err
+	}
 	g.popInst()
-	a0 := g.stack.pop()
-	a1 := g.stack.pop()
-	a := g.newFreeAddr()
-	g.heap[a] = &nodeApp{a0, a1}
-	g.stack.push(a)
+	a0 := g.stack.Pop()
+	a1 := g.stack.Pop()
+	a := g.heap.Alloc(&nodeApp{Left: a0, Right: a1})
+	g.stack.Push(a)
+
+	return nil
 }
 
-func (i instUnwind) execute(g *gVM) {
-	a := g.stack.peek(0)
-	heapNode := g.heap[a]
+func (i instUnwind) execute(g *gVM) error {
+	if err := g.stack.Require(1); err != nil {
+		return err
+	}
+	a := g.stack.Peek(0)
+	heapNode := g.heap.Nodes[a]
 	if heapNode == nil {
-		panic("Unexpected nil")
+		return fmt.Errorf("Unwinding unallocated address: %d", a)
 	}
-	fmt.Printf("\tUnwinding: %v\n", heapNode)
+	g.tracef(traceInst, "\tUnwinding: %v\n", heapNode)
 	if appNode, ok := heapNode.(*nodeApp); ok {
-		g.stack.push(appNode.left)
-	} else if globalNode, ok := heapNode.(*nodeGlobal); ok {
+		g.stack.Push(appNode.Left)
+	} else if globalNode, ok := heapNode.(*nodeGlobal); ok && len(g.stack.Data) > globalNode.arity {
 		for i := 1; i <= int(globalNode.arity); i++ {
-			ak := g.stack.data[len(g.stack.data)-1-i]
-			appNode, ok := g.heap[ak].(*nodeApp)
+			ak := g.stack.Data[len(g.stack.Data)-1-i]
+			appNode, ok := g.heap.Nodes[ak].(*nodeApp)
 			if !ok {
-				panic("Stack is not appNode")
+				return fmt.Errorf("Expected application node for argument %d of %s, got %v", i, globalNode.name, g.heap.Nodes[ak])
 			}
-			g.stack.data[len(g.stack.data)-i] = appNode.right
+			g.stack.Data[len(g.stack.Data)-i] = appNode.Right
 		}
 
 		newInst := make([]inst, 0)
@@ -183,135 +256,175 @@ func (i instUnwind) execute(g *gVM) {
 			newInst = append(newInst, globalNode.code[i])
 		}
 		g.insts = newInst
+		g.global = globalNode.name
 	} else if indNode, ok := heapNode.(*nodeInd); ok {
-		g.stack.pop()
-		g.stack.push(indNode.next)
+		// A hole is a letrec binding whose value needs itself
+		if indNode.Next == holeAddr {
+			return fmt.Errorf("Value depends on itself")
+		}
+		g.stack.Pop()
+		g.stack.Push(indNode.Next)
 	} else if len(g.dump) > 0 {
-		a = g.stack.pop()
+		// The spine is in WHNF, also when it is a global applied to too few
+		// arguments, and its value is the node at the root
+		a = g.stack.Data[0]
 		de := g.dump[len(g.dump)-1]
 		g.dump = g.dump[:len(g.dump)-1]
 		g.insts = de.insts
 		g.stack = &de.stack
-		g.stack.push(a)
+		g.global = de.global
+		g.stack.Push(a)
+	} else {
+		g.popInst()
 	}
 
-	fmt.Println("\tAfter Unwinding:")
-	fmt.Printf("\t%v\n", g)
+	g.tracef(traceState, "\tAfter Unwinding:\n\t%v\n", g)
+
+	return nil
 }
 
-func (i instUpdate) execute(g *gVM) {
+func (i instUpdate) execute(g *gVM) error {
+	if err := g.stack.Require(i.offset + 2); err != nil {
+		return err
+	}
 	g.popInst()
-	a := g.stack.pop()
-	an := g.stack.peek(i.offset)
-	g.heap[an] = &nodeInd{a}
+	a := g.stack.Pop()
+	g.heap.Update(g.stack.Peek(i.offset), a)
+
+	return nil
 }
 
-func (ins instPack) execute(g *gVM) {
+func (ins instPack) execute(g *gVM) error {
+	if err := g.stack.Require(ins.size); err != nil {
+		return err
+	}
 	g.popInst()
-	a := g.newFreeAddr()
 	arr := make([]addrType, ins.size)
 	for i := 0; i < ins.size; i++ {
-		arr[i] = g.stack.pop()
+		arr[i] = g.stack.Pop()
 	}
-	g.heap[a] = &nodeData{ins.tag, arr}
-	g.stack.push(a)
+	a := g.heap.Alloc(&nodeData{Tag: ins.tag, Fields: arr})
+	g.stack.Push(a)
+
+	return nil
 }
 
-func (ins instSplit) execute(g *gVM) {
-	g.popInst()
-	a := g.stack.pop()
-	dataNode, ok := g.heap[a].(*nodeData)
+func (ins instSplit) execute(g *gVM) error {
+	if err := g.stack.Require(1); err != nil {
+		return err
+	}
+	dataNode, ok := g.heap.Nodes[g.stack.Peek(0)].(*nodeData)
 	if !ok {
-		panic("Expected data node")
+		return fmt.Errorf("Expected data node, got %v", g.heap.Nodes[g.stack.Peek(0)])
 	}
-	for i := len(dataNode.array) - 1; i >= 0; i-- {
-		g.stack.push(dataNode.array[i])
+	g.popInst()
+	g.stack.Pop()
+	for i := len(dataNode.Fields) - 1; i >= 0; i-- {
+		g.stack.Push(dataNode.Fields[i])
 	}
+
+	return nil
 }
 
-func (ins instJump) execute(g *gVM) {
-	g.popInst()
-	a := g.stack.peek(0)
-	dataNode, ok := g.heap[a].(*nodeData)
+func (ins instJump) execute(g *gVM) error {
+	if err := g.stack.Require(1); err != nil {
+		return err
+	}
+	a := g.stack.Peek(0)
+	dataNode, ok := g.heap.Nodes[a].(*nodeData)
 	if !ok {
-		panic("Expected data node")
+		return fmt.Errorf("Expected data node, got %v", g.heap.Nodes[a])
 	}
-	for i := len(ins.branches[dataNode.tag]) - 1; i >= 0; i-- {
-		g.insts = append(g.insts, ins.branches[dataNode.tag][i])
+	b, ok := ins.tagMappings[dataNode.Tag]
+	if !ok {
+		return fmt.Errorf("No branch for tag %d", dataNode.Tag)
 	}
+	g.popInst()
+	for i := len(ins.branches[b]) - 1; i >= 0; i-- {
+		g.insts = append(g.insts, ins.branches[b][i])
+	}
+
+	return nil
 }
 
-func (ins instSlide) execute(g *gVM) {
+func (ins instSlide) execute(g *gVM) error {
+	if err := g.stack.Require(ins.offset + 1); err != nil {
+		return err
+	}
 	g.popInst()
-	a0 := g.stack.pop()
+	a0 := g.stack.Pop()
 	for i := 1; i <= ins.offset; i++ {
-		g.stack.pop()
+		g.stack.Pop()
 	}
-	g.stack.push(a0)
+	g.stack.Push(a0)
+
+	return nil
 }
 
-func (ins instBinOp) execute(g *gVM) {
-	g.popInst()
-	a0 := g.stack.pop()
-	n, ok := g.heap[a0].(*nodeNum)
-	if !ok {
-		panic("Not a number")
-	}
-	a1 := g.stack.pop()
-	m, ok := g.heap[a1].(*nodeNum)
-	if !ok {
-		panic("Not a number")
+func (ins instBinOp) execute(g *gVM) error {
+	if err := g.stack.Require(2); err != nil {
+		return err
 	}
+	n := g.heap.Nodes[g.stack.Peek(0)]
+	m := g.heap.Nodes[g.stack.Peek(1)]
 
-	result := &nodeNum{0}
-
-	switch ins.op {
-	case binOpPlus:
-		result.value = n.value + m.value
-	case binOpMinus:
-		result.value = n.value - m.value
-	case binOpTimes:
-		result.value = n.value * m.value
-	case binOpDivide:
-		result.value = n.value / m.value
-	default:
-		panic("Unsupported BinOp")
+	result, err := g.arith(ins.op, n, m)
+	if err != nil {
+		return atPosition(ins.pos, err)
 	}
 
-	a := g.newFreeAddr()
-	g.heap[a] = result
-	g.stack.push(a)
+	g.popInst()
+	g.stack.Pop()
+	g.stack.Pop()
+	a := g.heap.Alloc(result)
+	g.stack.Push(a)
+
+	return nil
 }
 
-func (ins instEval) execute(g *gVM) {
+func (ins instEval) execute(g *gVM) error {
+	if err := g.stack.Require(1); err != nil {
+		return err
+	}
 	g.popInst()
 	newInst := make([]inst, 1)
 	newInst[0] = &instUnwind{}
-	a := g.stack.pop()
-	newStack := newStack()
-	newStack.push(a)
+	a := g.stack.Pop()
+	newStack := rt.NewStack()
+	newStack.Push(a)
 
 	de := dumpEntry{
 		g.insts,
 		*g.stack,
+		g.global,
+		0,
 	}
 
 	g.dump = append(g.dump, de)
 	g.insts = newInst
 	g.stack = newStack
+
+	return nil
 }
 
-func (ins instAlloc) execute(g *gVM) {
+func (ins instAlloc) execute(g *gVM) error {
 	g.popInst()
 	for i := 0; i < ins.amount; i++ {
-		ak := g.newFreeAddr()
-		g.heap[ak] = &nodeInd{}
+		ak := g.heap.Alloc(&nodeInd{Next: holeAddr})
+		g.stack.Push(ak)
 	}
+
+	return nil
 }
 
-func (ins instPop) execute(g *gVM) {
+func (ins instPop) execute(g *gVM) error {
+	if err := g.stack.Require(ins.count); err != nil {
+		return err
+	}
 	g.popInst()
 	for i := 0; i < ins.count; i++ {
-		g.stack.pop()
+		g.stack.Pop()
 	}
+
+	return nil
 }
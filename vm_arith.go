@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/pocket7878/fngo/rt"
+)
+
+func parseOverflowMode(s string) (overflowMode, error) {
+	switch s {
+	case "wrap":
+		return overflowWrap, nil
+	case "trap":
+		return overflowTrap, nil
+	case "bignum":
+		return overflowBignum, nil
+	default:
+		return overflowWrap, fmt.Errorf("Unknown overflow mode: %s", s)
+	}
+}
+
+func bigValue(n node) (*big.Int, bool) {
+	switch num := n.(type) {
+	case *nodeNum:
+		return big.NewInt(int64(num.Value)), true
+	case *nodeBigNum:
+		return num.value, true
+	default:
+		return nil, false
+	}
+}
+
+// Apply op to two number nodes, following the overflow mode of the VM
+func (g *gVM) arith(op binOpType, l node, r node) (node, error) {
+	ln, lok := l.(*nodeNum)
+	rn, rok := r.(*nodeNum)
+
+	if isComparison(op) {
+		return compare(op, l, r)
+	}
+
+	if lok && rok && g.overflow == overflowWrap {
+		return rt.IntArith(op, ln.Value, rn.Value)
+	}
+
+	lv, ok := bigValue(l)
+	if !ok {
+		return nil, fmt.Errorf("Not a number: %v", l)
+	}
+	rv, ok := bigValue(r)
+	if !ok {
+		return nil, fmt.Errorf("Not a number: %v", r)
+	}
+
+	result, err := bigArith(op, lv, rv)
+	if err != nil {
+		return nil, err
+	}
+
+	if result.IsInt64() {
+		return &nodeNum{Value: int(result.Int64())}, nil
+	}
+
+	if g.overflow == overflowBignum {
+		return &nodeBigNum{result}, nil
+	}
+
+	o, _ := opName(op)
+	return nil, fmt.Errorf("Integer overflow: %v %s %v", lv, o, rv)
+}
+
+func bigArith(op binOpType, l *big.Int, r *big.Int) (*big.Int, error) {
+	result := new(big.Int)
+
+	switch op {
+	case binOpPlus:
+		return result.Add(l, r), nil
+	case binOpMinus:
+		return result.Sub(l, r), nil
+	case binOpTimes:
+		return result.Mul(l, r), nil
+	case binOpDivide:
+		if r.Sign() == 0 {
+			return nil, fmt.Errorf("Division by zero")
+		}
+		return result.Quo(l, r), nil
+	default:
+		return nil, fmt.Errorf("Unsupported BinOp: %d", op)
+	}
+}
+
+// Compare two number nodes, giving a Bool data node
+func compare(op binOpType, l node, r node) (node, error) {
+	var c int
+
+	ln, lok := l.(*nodeNum)
+	rn, rok := r.(*nodeNum)
+	if lok && rok {
+		switch {
+		case ln.Value < rn.Value:
+			c = -1
+		case ln.Value > rn.Value:
+			c = 1
+		}
+	} else {
+		lv, ok := bigValue(l)
+		if !ok {
+			return nil, fmt.Errorf("Not a number: %v", l)
+		}
+		rv, ok := bigValue(r)
+		if !ok {
+			return nil, fmt.Errorf("Not a number: %v", r)
+		}
+		c = lv.Cmp(rv)
+	}
+
+	return rt.Compare(op, c)
+}
@@ -0,0 +1,446 @@
+package main
+
+import (
+	"fmt"
+	"text/scanner"
+
+	"github.com/pocket7878/fngo/rt"
+)
+
+type interpreter = int
+
+const (
+	// Executes the inst values of a global, kept as a reversed stack
+	interpInst interpreter = iota
+	// Executes the flat integer encoding of the code with a program counter
+	interpDense
+)
+
+type opcode = int
+
+// Operands follow their opcode in the code array
+const (
+	opPushInt    opcode = iota // value
+	opPushGlobal               // symbol
+	opPush                     // offset
+	opPop                      // count
+	opMkApp
+	opUpdate // offset
+	opPack   // tag, size
+	opSplit
+	opJump  // n, then the target of each tag below n, or -1
+	opGoto  // target
+	opSlide // offset
+	opBinOp // operator, position
+	opEval
+	opAlloc // amount
+	opUnwind
+	opHalt
+)
+
+// Code every evaluation starts from. Eval returns to the Halt after it, and
+// Eval continues at the Unwind.
+const (
+	denseEvalStub   = 0
+	denseUnwindStub = 2
+)
+
+func parseInterpreter(s string) (interpreter, error) {
+	switch s {
+	case "inst":
+		return interpInst, nil
+	case "dense":
+		return interpDense, nil
+	default:
+		return interpDense, fmt.Errorf("Unknown interpreter: %s", s)
+	}
+}
+
+func (g *gVM) emit(op opcode, operands ...int) {
+	g.code = append(g.code, op)
+	g.code = append(g.code, operands...)
+}
+
+// Globals are referred to by symbol, so code can be assembled before the
+// globals it uses are added
+func (g *gVM) symbol(name string) int {
+	s, ok := g.symbols[name]
+	if !ok {
+		s = len(g.symbolNames)
+		g.symbols[name] = s
+		g.symbolNames = append(g.symbolNames, name)
+		g.symbolAddrs = append(g.symbolAddrs, holeAddr)
+	}
+
+	return s
+}
+
+// Lengths of the dense code and of the tables it indexes
+type denseMark struct {
+	code      int
+	positions int
+	symbols   int
+}
+
+func (g *gVM) mark() denseMark {
+	return denseMark{len(g.code), len(g.positions), len(g.symbolNames)}
+}
+
+// Drop the code assembled since m, and the globals whose symbols were made
+// since, so the collector may reclaim them and the values they were updated
+// with
+func (g *gVM) truncate(m denseMark) {
+	for _, name := range g.symbolNames[m.symbols:] {
+		delete(g.symbols, name)
+		delete(g.globalMap, name)
+	}
+	g.code = g.code[:m.code]
+	g.positions = g.positions[:m.positions]
+	g.symbolNames = g.symbolNames[:m.symbols]
+	g.symbolAddrs = g.symbolAddrs[:m.symbols]
+}
+
+// Append the dense encoding of code to the code array and return where it
+// starts. The branches of a Jump follow it, each but the last ending in a
+// Goto past the others.
+func (g *gVM) assemble(code []inst) int {
+	start := len(g.code)
+	for _, i := range code {
+		switch i := i.(type) {
+		case instPushInt:
+			g.emit(opPushInt, i.value)
+		case instPushGlobal:
+			g.emit(opPushGlobal, g.symbol(i.name))
+		case instPush:
+			g.emit(opPush, i.offset)
+		case instPop:
+			g.emit(opPop, i.count)
+		case instMkApp:
+			g.emit(opMkApp)
+		case instUpdate:
+			g.emit(opUpdate, i.offset)
+		case instPack:
+			g.emit(opPack, i.tag, i.size)
+		case instSplit:
+			g.emit(opSplit)
+		case instJump:
+			g.assembleJump(i)
+		case instSlide:
+			g.emit(opSlide, i.offset)
+		case instBinOp:
+			g.emit(opBinOp, i.op, len(g.positions))
+			g.positions = append(g.positions, i.pos)
+		case instEval:
+			g.emit(opEval)
+		case instAlloc:
+			g.emit(opAlloc, i.amount)
+		case instUnwind:
+			g.emit(opUnwind)
+		default:
+			panic(fmt.Sprintf("Unknown instruction: %v", i))
+		}
+	}
+
+	return start
+}
+
+func (g *gVM) assembleJump(i instJump) {
+	tags := 0
+	for tag := range i.tagMappings {
+		if tag+1 > tags {
+			tags = tag + 1
+		}
+	}
+
+	g.emit(opJump, tags)
+	table := len(g.code)
+	for t := 0; t < tags; t++ {
+		g.code = append(g.code, -1)
+	}
+
+	starts := make([]int, len(i.branches))
+	gotos := make([]int, 0)
+	for b, branch := range i.branches {
+		starts[b] = g.assemble(branch)
+		if b < len(i.branches)-1 {
+			g.emit(opGoto, 0)
+			gotos = append(gotos, len(g.code)-1)
+		}
+	}
+
+	for tag, b := range i.tagMappings {
+		g.code[table+tag] = starts[b]
+	}
+	for _, at := range gotos {
+		g.code[at] = len(g.code)
+	}
+}
+
+// Decode the instruction at pc for traces and error messages
+func (g *gVM) disassemble(pc int) string {
+	c := g.code
+	switch c[pc] {
+	case opPushInt:
+		return instPushInt{c[pc+1]}.String()
+	case opPushGlobal:
+		return instPushGlobal{g.symbolNames[c[pc+1]]}.String()
+	case opPush:
+		return instPush{c[pc+1]}.String()
+	case opPop:
+		return instPop{c[pc+1]}.String()
+	case opMkApp:
+		return instMkApp{}.String()
+	case opUpdate:
+		return instUpdate{c[pc+1]}.String()
+	case opPack:
+		return instPack{c[pc+1], c[pc+2]}.String()
+	case opSplit:
+		return instSplit{}.String()
+	case opJump:
+		result := "Jump("
+		for tag := 0; tag < c[pc+1]; tag++ {
+			if tag > 0 {
+				result += ", "
+			}
+			result += fmt.Sprintf("%d -> %d", tag, c[pc+2+tag])
+		}
+		return result + ")"
+	case opGoto:
+		return fmt.Sprintf("Goto(%d)", c[pc+1])
+	case opSlide:
+		return instSlide{c[pc+1]}.String()
+	case opBinOp:
+		return instBinOp{c[pc+1], scanner.Position{}}.String()
+	case opEval:
+		return instEval{}.String()
+	case opAlloc:
+		return instAlloc{c[pc+1]}.String()
+	case opUnwind:
+		return instUnwind{}.String()
+	case opHalt:
+		return "Halt()"
+	default:
+		return fmt.Sprintf("Unknown(%d)", c[pc])
+	}
+}
+
+func (g *gVM) runDense() error {
+	err := g.denseLoop()
+	if err != nil {
+		return g.runtimeError(g.disassemble(g.pc), err)
+	}
+
+	return nil
+}
+
+// Execute from g.pc until Halt, or until a value is reached with nothing
+// left on the dump. On error g.pc is left at the failing instruction.
+func (g *gVM) denseLoop() error {
+	code := g.code
+	for {
+		g.maybeCollect()
+		if g.trace > traceResult {
+			g.tracef(traceState, "BEFORE VM\n%v", g)
+			g.tracef(traceInst, "Execute: %s\n", g.disassemble(g.pc))
+		}
+
+		pc := g.pc
+		switch code[pc] {
+		case opPushInt:
+			a := g.heap.Alloc(&nodeNum{Value: code[pc+1]})
+			g.stack.Push(a)
+			g.pc = pc + 2
+
+		case opPushGlobal:
+			a := g.symbolAddrs[code[pc+1]]
+			if a == holeAddr {
+				return fmt.Errorf("Undefined function: %s", g.symbolNames[code[pc+1]])
+			}
+			g.stack.Push(a)
+			g.pc = pc + 2
+
+		case opPush:
+			if err := g.stack.Require(code[pc+1] + 1); err != nil {
+				return err
+			}
+			g.stack.Push(g.stack.Peek(code[pc+1]))
+			g.pc = pc + 2
+
+		case opPop:
+			if err := g.stack.Require(code[pc+1]); err != nil {
+				return err
+			}
+			g.stack.Data = g.stack.Data[:len(g.stack.Data)-code[pc+1]]
+			g.pc = pc + 2
+
+		case opMkApp:
+			if err := g.stack.Require(2); err != nil {
+				return err
+			}
+			a0 := g.stack.Pop()
+			a1 := g.stack.Pop()
+			a := g.heap.Alloc(&nodeApp{Left: a0, Right: a1})
+			g.stack.Push(a)
+			g.pc = pc + 1
+
+		case opUpdate:
+			if err := g.stack.Require(code[pc+1] + 2); err != nil {
+				return err
+			}
+			a := g.stack.Pop()
+			g.heap.Update(g.stack.Peek(code[pc+1]), a)
+			g.pc = pc + 2
+
+		case opPack:
+			size := code[pc+2]
+			if err := g.stack.Require(size); err != nil {
+				return err
+			}
+			arr := make([]addrType, size)
+			for i := 0; i < size; i++ {
+				arr[i] = g.stack.Pop()
+			}
+			a := g.heap.Alloc(&nodeData{Tag: code[pc+1], Fields: arr})
+			g.stack.Push(a)
+			g.pc = pc + 3
+
+		case opSplit:
+			if err := g.stack.Require(1); err != nil {
+				return err
+			}
+			dataNode, ok := g.heap.Nodes[g.stack.Peek(0)].(*nodeData)
+			if !ok {
+				return fmt.Errorf("Expected data node, got %v", g.heap.Nodes[g.stack.Peek(0)])
+			}
+			g.stack.Pop()
+			for i := len(dataNode.Fields) - 1; i >= 0; i-- {
+				g.stack.Push(dataNode.Fields[i])
+			}
+			g.pc = pc + 1
+
+		case opJump:
+			if err := g.stack.Require(1); err != nil {
+				return err
+			}
+			dataNode, ok := g.heap.Nodes[g.stack.Peek(0)].(*nodeData)
+			if !ok {
+				return fmt.Errorf("Expected data node, got %v", g.heap.Nodes[g.stack.Peek(0)])
+			}
+			if dataNode.Tag < 0 || dataNode.Tag >= code[pc+1] || code[pc+2+dataNode.Tag] < 0 {
+				return fmt.Errorf("No branch for tag %d", dataNode.Tag)
+			}
+			g.pc = code[pc+2+dataNode.Tag]
+
+		case opGoto:
+			g.pc = code[pc+1]
+
+		case opSlide:
+			n := code[pc+1]
+			if err := g.stack.Require(n + 1); err != nil {
+				return err
+			}
+			a0 := g.stack.Pop()
+			g.stack.Data = g.stack.Data[:len(g.stack.Data)-n]
+			g.stack.Push(a0)
+			g.pc = pc + 2
+
+		case opBinOp:
+			if err := g.stack.Require(2); err != nil {
+				return err
+			}
+			result, err := g.arith(code[pc+1], g.heap.Nodes[g.stack.Peek(0)], g.heap.Nodes[g.stack.Peek(1)])
+			if err != nil {
+				return atPosition(g.positions[code[pc+2]], err)
+			}
+			g.stack.Pop()
+			g.stack.Pop()
+			a := g.heap.Alloc(result)
+			g.stack.Push(a)
+			g.pc = pc + 3
+
+		case opEval:
+			if err := g.stack.Require(1); err != nil {
+				return err
+			}
+			a := g.stack.Pop()
+			g.dump = append(g.dump, dumpEntry{nil, *g.stack, g.global, pc + 1})
+			g.stack = rt.NewStack()
+			g.stack.Push(a)
+			g.pc = denseUnwindStub
+
+		case opAlloc:
+			for i := 0; i < code[pc+1]; i++ {
+				a := g.heap.Alloc(&nodeInd{Next: holeAddr})
+				g.stack.Push(a)
+			}
+			g.pc = pc + 2
+
+		case opUnwind:
+			if err := g.stack.Require(1); err != nil {
+				return err
+			}
+			halt, err := g.unwindDense()
+			if err != nil || halt {
+				return err
+			}
+
+		case opHalt:
+			return nil
+
+		default:
+			return fmt.Errorf("Unknown opcode: %d", code[pc])
+		}
+	}
+}
+
+// Unwind leaves g.pc at the Unwind while it walks down the spine, so the
+// next step unwinds again
+func (g *gVM) unwindDense() (bool, error) {
+	a := g.stack.Peek(0)
+	switch n := g.heap.Nodes[a].(type) {
+	case *nodeApp:
+		g.stack.Push(n.Left)
+	case *nodeInd:
+		if n.Next == holeAddr {
+			return false, fmt.Errorf("Value depends on itself")
+		}
+		g.stack.Data[len(g.stack.Data)-1] = n.Next
+	case *nodeGlobal:
+		if len(g.stack.Data) <= n.arity {
+			// A partial application is in WHNF, its value is the root of the spine
+			return g.returnDense(g.stack.Data[0]), nil
+		}
+		for i := 1; i <= n.arity; i++ {
+			ak := g.stack.Data[len(g.stack.Data)-1-i]
+			appNode, ok := g.heap.Nodes[ak].(*nodeApp)
+			if !ok {
+				return false, fmt.Errorf("Expected application node for argument %d of %s, got %v", i, n.name, g.heap.Nodes[ak])
+			}
+			g.stack.Data[len(g.stack.Data)-i] = appNode.Right
+		}
+		g.pc = n.entry
+		g.global = n.name
+	case nil:
+		return false, fmt.Errorf("Unwinding unallocated address: %d", a)
+	default:
+		return g.returnDense(a), nil
+	}
+
+	return false, nil
+}
+
+// Return the value a to the evaluation waiting in the dump, or halt when
+// there is none
+func (g *gVM) returnDense(a addrType) bool {
+	if len(g.dump) == 0 {
+		return true
+	}
+	de := g.dump[len(g.dump)-1]
+	g.dump = g.dump[:len(g.dump)-1]
+	g.pc = de.pc
+	g.stack = &de.stack
+	g.global = de.global
+	g.stack.Push(a)
+
+	return false
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Error raised while executing G-code. The VM is left as it was right
+// before the failing instruction.
+type runtimeError struct {
+	inst   string
+	global string
+	stack  []addrType
+	nodes  []node
+	err    error
+}
+
+func (e runtimeError) Error() string {
+	result := "Runtime error"
+	if e.global != "" {
+		result += fmt.Sprintf(" in %s", e.global)
+	}
+	result += fmt.Sprintf(" at %v: %v\n", e.inst, e.err)
+	result += "stack:"
+	for i := len(e.stack) - 1; i >= 0; i-- {
+		result += fmt.Sprintf("\n\t%d: %v", e.stack[i], e.nodes[i])
+	}
+
+	return result
+}
+
+func (e runtimeError) Cause() error {
+	return e.err
+}
+
+func (g *gVM) runtimeError(i string, err error) error {
+	snapshot := make([]addrType, len(g.stack.Data))
+	copy(snapshot, g.stack.Data)
+
+	nodes := make([]node, len(snapshot))
+	for i, a := range snapshot {
+		nodes[i] = g.heap.Nodes[a]
+	}
+
+	return runtimeError{i, g.global, snapshot, nodes, err}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRuntimeError(t *testing.T) {
+	cases := []struct {
+		err  runtimeError
+		want string
+	}{
+		{
+			runtimeError{"Eval()", "", nil, nil, fmt.Errorf("Stack underflow")},
+			"Runtime error at Eval(): Stack underflow\nstack:",
+		},
+		{
+			runtimeError{"Unwind()", "main", []addrType{2, 3}, []node{&nodeGlobal{"main", 0, nil, 0}, &nodeNum{Value: 1}}, fmt.Errorf("Unwinding a hole")},
+			"Runtime error in main at Unwind(): Unwinding a hole\nstack:\n\t3: NInt 1\n\t2: NGlobal main/0",
+		},
+	}
+
+	for _, c := range cases {
+		if got := c.err.Error(); got != c.want {
+			t.Errorf("got %q, want %q", got, c.want)
+		}
+	}
+}